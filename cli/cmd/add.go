@@ -95,7 +95,11 @@ func (c *cmdAdd) add(app string, instanceName string) error {
 		os.Exit(1)
 	}
 	if doit {
-		installFunc, err := downloadRaw(repository, application.InstallMethods[0].Script)
+		source, err := findCatalogSource(application.Source)
+		if err != nil {
+			return err
+		}
+		installFunc, err := source.download(application.InstallMethods[0].Script)
 		if err != nil {
 			fmt.Println("Error downloading install script:", err)
 			os.Exit(1)
@@ -0,0 +1,637 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/spf13/cobra"
+)
+
+type cmdBundle struct {
+	global *cmdGlobal
+}
+
+func (c *cmdBundle) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "bundle"
+	cmd.Short = "create and import offline bundles"
+	cmd.Long =
+		`Create and import offline bundles
+
+A bundle packages catalog applications, their scripts and the incus images they use
+so they can be launched on hosts without internet access.`
+
+	createCmd := cmdBundleCreate{global: c.global}
+	cmd.AddCommand(createCmd.Command())
+
+	importCmd := cmdBundleImport{global: c.global}
+	cmd.AddCommand(importCmd.Command())
+
+	// Workaround for subcommand usage errors. See: https://github.com/spf13/cobra/issues/706
+	cmd.Args = cobra.NoArgs
+	cmd.Run = func(cmd *cobra.Command, args []string) { _ = cmd.Usage() }
+	return cmd
+}
+
+const bundleManifestFile = "manifest.json"
+
+// bundleManifest describes the contents of a bundle.
+type bundleManifest struct {
+	Created time.Time     `json:"created"`
+	Apps    []bundleApp   `json:"apps"`
+	Images  []bundleImage `json:"images"`
+}
+
+type bundleApp struct {
+	Name   string `json:"name"`
+	Slug   string `json:"slug"`
+	Source string `json:"source"`
+	// Images are the fingerprints of the images the app's install methods use.
+	Images []string `json:"images"`
+	// NeedsInternet is set when the installer still downloads from the internet.
+	NeedsInternet   bool     `json:"needs_internet"`
+	InternetReasons []string `json:"internet_reasons,omitempty"`
+}
+
+type bundleImage struct {
+	Fingerprint string `json:"fingerprint"`
+	Alias       string `json:"alias"`
+	Type        string `json:"type"`
+	MetaFile    string `json:"meta_file"`
+	RootfsFile  string `json:"rootfs_file,omitempty"`
+}
+
+// bundleSharedFiles are needed by every launch from a bundle.
+var bundleSharedFiles = []string{
+	"json/metadata.json",
+	"misc/install.func",
+	"misc/alpine-install.func",
+	"misc/tools.func",
+}
+
+// internetPatterns flag installer lines that need internet access.
+var internetPatterns = []struct {
+	re     *regexp.Regexp
+	reason string
+}{
+	{regexp.MustCompile(`\b(curl|wget)\b`), "downloads files with curl or wget"},
+	{regexp.MustCompile(`\bgit clone\b`), "clones git repositories"},
+	{regexp.MustCompile(`\b(apt-get|apt) (-\S+ )*install\b`), "installs apt packages"},
+	{regexp.MustCompile(`\bapk (-\S+ )*add\b`), "installs apk packages"},
+	{regexp.MustCompile(`\b(npm|pnpm|yarn) (-\S+ )*(install|add|ci)\b`), "installs node packages"},
+	{regexp.MustCompile(`\b(pip3?|uv) (-\S+ )*install\b`), "installs python packages"},
+}
+
+// internetReasons lists why a script needs internet access.
+func internetReasons(script []byte) []string {
+	var reasons []string
+	for _, p := range internetPatterns {
+		if p.re.Match(script) {
+			reasons = append(reasons, p.reason)
+		}
+	}
+	return reasons
+}
+
+type cmdBundleCreate struct {
+	global *cmdGlobal
+
+	flagApps   []string
+	flagOutput string
+	flagVM     bool
+}
+
+func (c *cmdBundleCreate) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "create"
+	cmd.Short = "create an offline bundle"
+	cmd.Args = cobra.NoArgs
+	cmd.Long =
+		`Create an offline bundle
+
+Packages the catalog entries, metadata.json, install scripts, install.func,
+alpine-install.func, tools.func and incus images for the given applications.
+
+The output is compressed with zstd for .tar.zst (using the zstd command) or gzip
+for .tar.gz. Installers that still need internet access are flagged in the manifest.`
+	cmd.Example = `  scripts-cli bundle create --apps grafana,redis,immich -o bundle.tar.zst`
+	cmd.Flags().StringSliceVar(&c.flagApps, "apps", nil, "applications to include")
+	cmd.Flags().StringVarP(&c.flagOutput, "output", "o", "bundle.tar.zst", "bundle file to write")
+	cmd.Flags().BoolVar(&c.flagVM, "vm", false, "include virtual machine images instead of container images")
+	_ = cmd.MarkFlagRequired("apps")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdBundleCreate) Run(cmd *cobra.Command, args []string) error {
+	stage, err := os.MkdirTemp("", "scriptcli-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+
+	manifest := bundleManifest{Created: time.Now().UTC()}
+	index := map[string]Application{}
+	exported := map[string]bool{}
+	var sharedSource catalogSource
+	for i, ref := range c.flagApps {
+		a, err := getAppMetadata(ref)
+		if err != nil {
+			return err
+		}
+		src, err := findCatalogSource(a.Source)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			sharedSource = src
+		}
+		_, name := splitAppRef(ref)
+		log.Info("Adding application", "application", a.Name, "source", a.Source)
+
+		files := []string{filepath.Join("json", name+".json")}
+		for _, m := range a.InstallMethods {
+			files = append(files, m.Script)
+		}
+		installer := ""
+		if a.Type == "ct" {
+			installer = a.Installer()
+			files = append(files, installer)
+			index[name] = *a
+		}
+
+		entry := bundleApp{Name: a.Name, Slug: name, Source: a.Source}
+		for _, f := range files {
+			bb, err := src.download(f)
+			if err != nil {
+				return fmt.Errorf("%s: %w", a.Slug, err)
+			}
+			err = writeStageFile(stage, f, bb)
+			if err != nil {
+				return err
+			}
+			if f != installer {
+				// the ct scripts always fetch build.func, only the installer runs offline
+				continue
+			}
+			for _, r := range internetReasons(bb) {
+				if !slices.Contains(entry.InternetReasons, r) {
+					entry.InternetReasons = append(entry.InternetReasons, r)
+				}
+			}
+		}
+		entry.NeedsInternet = len(entry.InternetReasons) > 0
+
+		if a.Type == "ct" {
+			for _, m := range a.InstallMethods {
+				img, err := c.exportImage(server, stage, *a, m, exported)
+				if err != nil {
+					return err
+				}
+				if !slices.ContainsFunc(manifest.Images, func(i bundleImage) bool { return i.Fingerprint == img.Fingerprint }) {
+					manifest.Images = append(manifest.Images, *img)
+				}
+				entry.Images = append(entry.Images, img.Fingerprint)
+			}
+		}
+		manifest.Apps = append(manifest.Apps, entry)
+		if entry.NeedsInternet {
+			log.Warn("Installer still needs internet access", "application", a.Name, "reasons", strings.Join(entry.InternetReasons, ", "))
+		}
+	}
+
+	for _, f := range bundleSharedFiles {
+		bb, err := sharedSource.download(f)
+		if err != nil {
+			return err
+		}
+		err = writeStageFile(stage, f, bb)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeJSONFile(filepath.Join(stage, "json", legacyIndexFile), index)
+	if err != nil {
+		return err
+	}
+	err = writeJSONFile(filepath.Join(stage, bundleManifestFile), manifest)
+	if err != nil {
+		return err
+	}
+
+	err = writeArchive(stage, c.flagOutput)
+	if err != nil {
+		return err
+	}
+	log.Info("Created bundle", "file", c.flagOutput, "applications", len(manifest.Apps), "images", len(manifest.Images))
+	return nil
+}
+
+// exportImage caches the image of an install method, unless it is cached
+// already, and writes its files to the stage directory. Images already
+// exported are only looked up.
+func (c *cmdBundleCreate) exportImage(server incus.InstanceServer, stage string, a Application, m InstallMethods, exported map[string]bool) (*bundleImage, error) {
+	ref, err := c.global.selectImage(a, m.Resources, c.flagVM, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", a.Slug, err)
+	}
+	// cached images are exported as they are, keeping their auto-update setting
+	image, _, err := server.GetImage(ref.Fingerprint)
+	if err != nil {
+		image, err = c.global.cacheImage(server, ref, c.flagVM, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	img := &bundleImage{Fingerprint: image.Fingerprint, Alias: ref.Name, Type: image.Type}
+	if exported[image.Fingerprint] {
+		return img, nil
+	}
+	exported[image.Fingerprint] = true
+
+	err = os.MkdirAll(filepath.Join(stage, "images"), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	metaPath := filepath.Join("images", image.Fingerprint+".meta")
+	rootfsPath := filepath.Join("images", image.Fingerprint+".rootfs")
+	meta, err := os.Create(filepath.Join(stage, metaPath))
+	if err != nil {
+		return nil, err
+	}
+	defer meta.Close()
+	rootfs, err := os.Create(filepath.Join(stage, rootfsPath))
+	if err != nil {
+		return nil, err
+	}
+	defer rootfs.Close()
+
+	log.Info("Exporting image", "image", ref.String(), "fingerprint", image.Fingerprint[:12])
+	resp, err := server.GetImageFile(image.Fingerprint, incus.ImageFileRequest{MetaFile: meta, RootfsFile: rootfs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export image %s: %w", ref, err)
+	}
+	img.MetaFile = filepath.ToSlash(metaPath)
+	if resp.RootfsName != "" {
+		img.RootfsFile = filepath.ToSlash(rootfsPath)
+	} else {
+		// unified image, everything is in the meta file
+		rootfs.Close()
+		os.Remove(filepath.Join(stage, rootfsPath))
+	}
+	return img, nil
+}
+
+type cmdBundleImport struct {
+	global *cmdGlobal
+
+	flagName     string
+	flagPriority int
+	flagForce    bool
+}
+
+func (c *cmdBundleImport) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "import <bundle file>"
+	cmd.Short = "import an offline bundle"
+	cmd.Args = cobra.ExactArgs(1)
+	cmd.Long =
+		`Import an offline bundle
+
+Extracts the bundle below the scripts-cli configuration directory, registers it as a
+catalog source and loads its images into the local image store. The bundled
+applications are configured to launch from the loaded images.`
+	cmd.Example = `  scripts-cli bundle import bundle.tar.zst
+  scripts-cli launch offline/grafana grafana`
+	cmd.Flags().StringVar(&c.flagName, "name", "offline", "catalog source name for the bundle")
+	cmd.Flags().IntVar(&c.flagPriority, "priority", 100, "catalog source priority for the bundle")
+	cmd.Flags().BoolVar(&c.flagForce, "force", false, "replace a previously imported bundle with the same name")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdBundleImport) Run(cmd *cobra.Command, args []string) error {
+	if c.flagName == "" || strings.Contains(c.flagName, "/") {
+		return fmt.Errorf("invalid bundle name %q", c.flagName)
+	}
+	sources, err := catalogSources()
+	if err != nil {
+		return err
+	}
+	exists := slices.ContainsFunc(sources, func(s catalogSource) bool { return s.Name == c.flagName })
+	if exists && !c.flagForce {
+		return fmt.Errorf("catalog source %q already exists, use --force to replace it", c.flagName)
+	}
+
+	dest := filepath.Join(configDir(), "bundles", c.flagName)
+	err = os.RemoveAll(dest)
+	if err != nil {
+		return err
+	}
+	err = extractArchive(args[0], dest)
+	if err != nil {
+		return err
+	}
+
+	bb, err := os.ReadFile(filepath.Join(dest, bundleManifestFile))
+	if err != nil {
+		return fmt.Errorf("not a scripts-cli bundle: %w", err)
+	}
+	var manifest bundleManifest
+	err = json.Unmarshal(bb, &manifest)
+	if err != nil {
+		return fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	for _, img := range manifest.Images {
+		err = importImage(server, dest, img)
+		if err != nil {
+			return err
+		}
+	}
+
+	remote := c.global.conf.DefaultRemote
+	err = updateConfigFile(func(settings map[string]any) {
+		list := []any{}
+		for _, s := range sources {
+			if s.Name == c.flagName {
+				continue
+			}
+			entry := map[string]any{"name": s.Name, "priority": s.Priority}
+			if s.Path != "" {
+				entry["path"] = s.Path
+			} else {
+				entry["repository"] = s.Repository
+			}
+			list = append(list, entry)
+		}
+		list = append(list, map[string]any{"name": c.flagName, "path": dest, "priority": c.flagPriority})
+		settings["sources"] = list
+
+		apps := settingsMap(settings, "apps")
+		for _, a := range manifest.Apps {
+			if len(a.Images) > 0 {
+				settingsMap(apps, a.Slug)["image-remote"] = remote
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, a := range manifest.Apps {
+		if a.NeedsInternet {
+			log.Warn("Installer still needs internet access", "application", a.Name, "reasons", strings.Join(a.InternetReasons, ", "))
+		}
+	}
+	log.Info("Imported bundle", "source", c.flagName, "applications", len(manifest.Apps), "images", len(manifest.Images))
+	return nil
+}
+
+// importImage loads a bundled image into the local image store and gives it
+// the alias the catalog resolves to, unless that alias is already taken.
+func importImage(server incus.InstanceServer, dir string, img bundleImage) error {
+	_, _, err := server.GetImage(img.Fingerprint)
+	if err != nil {
+		meta, err := os.Open(filepath.Join(dir, img.MetaFile))
+		if err != nil {
+			return err
+		}
+		defer meta.Close()
+		args := &incus.ImageCreateArgs{MetaFile: meta, MetaName: filepath.Base(img.MetaFile), Type: img.Type}
+		if img.RootfsFile != "" {
+			rootfs, err := os.Open(filepath.Join(dir, img.RootfsFile))
+			if err != nil {
+				return err
+			}
+			defer rootfs.Close()
+			args.RootfsFile = rootfs
+			args.RootfsName = filepath.Base(img.RootfsFile)
+		}
+
+		log.Info("Loading image", "alias", img.Alias, "fingerprint", img.Fingerprint[:12])
+		op, err := server.CreateImage(api.ImagesPost{}, args)
+		if err != nil {
+			return fmt.Errorf("failed to load image %s: %w", img.Alias, err)
+		}
+		err = op.Wait()
+		if err != nil {
+			return fmt.Errorf("failed to load image %s: %w", img.Alias, err)
+		}
+	}
+
+	alias, _, err := server.GetImageAlias(img.Alias)
+	if err == nil {
+		if alias.Target != img.Fingerprint {
+			log.Warn("Image alias already points at another image", "alias", img.Alias, "fingerprint", alias.Target[:12])
+		}
+		return nil
+	}
+	return server.CreateImageAlias(api.ImageAliasesPost{
+		ImageAliasesEntry: api.ImageAliasesEntry{
+			ImageAliasesEntryPut: api.ImageAliasesEntryPut{Target: img.Fingerprint},
+			Name:                 img.Alias,
+			Type:                 img.Type,
+		},
+	})
+}
+
+func writeStageFile(stage string, name string, bb []byte) error {
+	path := filepath.Join(stage, name)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, bb, 0o644)
+}
+
+// compressor returns the external command that compresses or decompresses a
+// bundle, based on its file name. A nil command means gzip or no compression.
+func compressor(name string, decompress bool) (*exec.Cmd, error) {
+	if !strings.HasSuffix(name, ".zst") {
+		return nil, nil
+	}
+	_, err := exec.LookPath("zstd")
+	if err != nil {
+		return nil, errors.New("the zstd command is needed for .tar.zst bundles, install it or use .tar.gz")
+	}
+	if decompress {
+		return exec.Command("zstd", "-q", "-d", "-c", name), nil
+	}
+	return exec.Command("zstd", "-q", "-T0", "-f", "-o", name), nil
+}
+
+// writeArchive writes the contents of dir to a tar archive at name.
+func writeArchive(dir string, name string) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var w io.Writer = out
+	var closers []io.Closer
+	zstd, err := compressor(name, false)
+	if err != nil {
+		return err
+	}
+	if zstd != nil {
+		out.Close()
+		pipe, err := zstd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		zstd.Stderr = os.Stderr
+		err = zstd.Start()
+		if err != nil {
+			return err
+		}
+		w = pipe
+		closers = append(closers, pipe)
+	} else if strings.HasSuffix(name, ".gz") {
+		gz := gzip.NewWriter(out)
+		w = gz
+		closers = append(closers, gz)
+	}
+
+	tw := tar.NewWriter(w)
+	err = tw.AddFS(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	for _, c := range closers {
+		err = c.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if zstd != nil {
+		return zstd.Wait()
+	}
+	return nil
+}
+
+// extractArchive unpacks the tar archive name into dir.
+func extractArchive(name string, dir string) error {
+	var r io.Reader
+	zstd, err := compressor(name, true)
+	if err != nil {
+		return err
+	}
+	if zstd != nil {
+		zstd.Stderr = os.Stderr
+		out, err := zstd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		err = zstd.Start()
+		if err != nil {
+			return err
+		}
+		defer zstd.Wait()
+		r = out
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+		if strings.HasSuffix(name, ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			r = gz
+		}
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fs.ValidPath(strings.TrimSuffix(hdr.Name, "/")) {
+			return fmt.Errorf("invalid path %q in bundle", hdr.Name)
+		}
+		path := filepath.Join(dir, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0o755)
+		case tar.TypeReg:
+			err = extractFile(tr, path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func Test_internetReasons(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{"offline", "msg_info \"Configuring\"\nsystemctl enable -q --now app\n", 0},
+		{"curl", "curl -fsSL https://example.com/app.tar.gz -o app.tar.gz\n", 1},
+		{"apt", "$STD apt-get install -y nginx\n", 1},
+		{"several", "$STD apk add nodejs\n$STD npm ci\ngit clone https://example.com/app.git\n", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := internetReasons([]byte(tt.script)); len(got) != tt.want {
+				t.Errorf("internetReasons() = %v, want %d reasons", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_archiveRoundTrip(t *testing.T) {
+	for _, name := range []string{"bundle.tar", "bundle.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			stage := t.TempDir()
+			for _, f := range []string{"manifest.json", "json/app.json", "install/app-install.sh"} {
+				err := writeStageFile(stage, f, []byte(f))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			archive := filepath.Join(t.TempDir(), name)
+			err := writeArchive(stage, archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dest := t.TempDir()
+			err = extractArchive(archive, dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			err = filepath.WalkDir(dest, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					rel, _ := filepath.Rel(dest, path)
+					got = append(got, filepath.ToSlash(rel))
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			want := []string{"install/app-install.sh", "json/app.json", "manifest.json"}
+			if !slices.Equal(got, want) {
+				t.Errorf("extractArchive() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/lxc/incus/v6/shared/units"
+)
+
+// capacity is what the target host has available for a new instance.
+type capacity struct {
+	CPUs        int
+	TotalMemory int64
+	FreeMemory  int64
+	// PoolFree is the free space of the storage pool, -1 when unknown.
+	PoolFree int64
+	Pool     string
+}
+
+// requirement is what an instance needs, in CPUs and bytes.
+type requirement struct {
+	CPU    int
+	Memory int64
+	Disk   int64
+}
+
+// checkCapacity compares what an instance needs with what the host has. It
+// returns problems that are only worth a warning, and problems that stop the
+// launch unless it is forced.
+func checkCapacity(req requirement, c capacity) ([]string, []string) {
+	var warnings, problems []string
+	if c.CPUs > 0 && req.CPU > c.CPUs {
+		problems = append(problems, fmt.Sprintf("needs %d CPUs, the host has %d", req.CPU, c.CPUs))
+	}
+	switch {
+	case req.Memory == 0:
+	case c.TotalMemory > 0 && req.Memory > c.TotalMemory:
+		problems = append(problems, fmt.Sprintf("needs %s of memory, the host has %s", formatBytes(req.Memory), formatBytes(c.TotalMemory)))
+	case req.Memory > c.FreeMemory:
+		warnings = append(warnings, fmt.Sprintf("needs %s of memory, only %s is free", formatBytes(req.Memory), formatBytes(c.FreeMemory)))
+	}
+	switch {
+	case req.Disk == 0 || c.PoolFree < 0:
+	case req.Disk > c.PoolFree:
+		problems = append(problems, fmt.Sprintf("needs %s of disk, storage pool %s has %s free", formatBytes(req.Disk), c.Pool, formatBytes(c.PoolFree)))
+	case req.Disk > c.PoolFree*9/10:
+		warnings = append(warnings, fmt.Sprintf("needs %s of disk, leaving less than 10%% free on storage pool %s", formatBytes(req.Disk), c.Pool))
+	}
+	return warnings, problems
+}
+
+// launchRequirement returns what an instance launched with settings needs.
+func launchRequirement(settings LaunchSettings) (requirement, error) {
+	req := requirement{CPU: settings.CPU}
+	var err error
+	if settings.RAM != "" {
+		req.Memory, err = units.ParseByteSizeString(settings.RAM)
+		if err != nil {
+			return req, fmt.Errorf("invalid memory size %q: %w", settings.RAM, err)
+		}
+	}
+	if settings.RootDiskSize != "" {
+		req.Disk, err = units.ParseByteSizeString(settings.RootDiskSize)
+		if err != nil {
+			return req, fmt.Errorf("invalid disk size %q: %w", settings.RootDiskSize, err)
+		}
+	}
+	return req, nil
+}
+
+// preflight checks that the host has room for an instance before it is
+// created. force turns refusals into warnings.
+func (c *cmdGlobal) preflight(settings LaunchSettings, force bool) error {
+	req, err := launchRequirement(settings)
+	if err != nil {
+		return err
+	}
+	server, err := c.server()
+	if err != nil {
+		return err
+	}
+	res, err := server.GetServerResources()
+	if err != nil {
+		log.Warn("Skipping capacity checks, failed to get server resources", "error", err)
+		return nil
+	}
+	avail := capacity{
+		CPUs:        int(res.CPU.Total),
+		TotalMemory: int64(res.Memory.Total),
+		FreeMemory:  int64(res.Memory.Total) - int64(res.Memory.Used),
+		PoolFree:    -1,
+	}
+	avail.Pool, err = c.instancePool(settings)
+	if err == nil {
+		pool, err := server.GetStoragePoolResources(avail.Pool)
+		if err == nil && pool.Space.Total >= pool.Space.Used {
+			avail.PoolFree = int64(pool.Space.Total - pool.Space.Used)
+		}
+	}
+
+	warnings, problems := checkCapacity(req, avail)
+	for _, w := range warnings {
+		log.Warn("Instance may not fit", "instance", settings.Name, "reason", w)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if force {
+		for _, p := range problems {
+			log.Warn("Instance does not fit, launching anyway", "instance", settings.Name, "reason", p)
+		}
+		return nil
+	}
+	return errors.New("instance " + settings.Name + " does not fit on the host: " + strings.Join(problems, "; ") + " (use --force to launch anyway)")
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func Test_checkCapacity(t *testing.T) {
+	const gib = 1 << 30
+	host := capacity{CPUs: 4, TotalMemory: 16 * gib, FreeMemory: 4 * gib, PoolFree: 100 * gib, Pool: "default"}
+	tests := []struct {
+		name         string
+		req          requirement
+		host         capacity
+		wantWarnings int
+		wantProblems int
+	}{
+		{"fits", requirement{CPU: 2, Memory: 2 * gib, Disk: 8 * gib}, host, 0, 0},
+		{"tooManyCPUs", requirement{CPU: 8}, host, 0, 1},
+		{"memoryInUse", requirement{Memory: 8 * gib}, host, 1, 0},
+		{"tooMuchMemory", requirement{Memory: 32 * gib}, host, 0, 1},
+		{"poolAlmostFull", requirement{Disk: 95 * gib}, host, 1, 0},
+		{"poolFull", requirement{Disk: 200 * gib}, host, 0, 1},
+		{"poolUnknown", requirement{Disk: 200 * gib}, capacity{CPUs: 4, TotalMemory: 16 * gib, FreeMemory: 4 * gib, PoolFree: -1}, 0, 0},
+		{"everything", requirement{CPU: 8, Memory: 32 * gib, Disk: 200 * gib}, host, 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, problems := checkCapacity(tt.req, tt.host)
+			if len(warnings) != tt.wantWarnings || len(problems) != tt.wantProblems {
+				t.Errorf("checkCapacity() = %v, %v, want %d warnings and %d problems", warnings, problems, tt.wantWarnings, tt.wantProblems)
+			}
+		})
+	}
+}
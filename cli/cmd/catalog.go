@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+)
+
+// catalogSource is a repository or local directory laid out like this one.
+// When several sources provide the same slug, the highest priority wins.
+type catalogSource struct {
+	Name       string `mapstructure:"name"`
+	Repository string `mapstructure:"repository"`
+	Path       string `mapstructure:"path"`
+	Priority   int    `mapstructure:"priority"`
+}
+
+func (s catalogSource) String() string {
+	if s.Path != "" {
+		return s.Path
+	}
+	return s.Repository
+}
+
+// download reads a file from the source, relative to its root. Paths
+// leaving the root are refused.
+func (s catalogSource) download(paths ...string) ([]byte, error) {
+	rel := filepath.Join(paths...)
+	if !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("%s is outside the catalog", rel)
+	}
+	if s.Path == "" {
+		return downloadRaw(s.Repository, paths...)
+	}
+	name := filepath.Join(s.Path, rel)
+	bb, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, errNotFound)
+	}
+	return bb, err
+}
+
+// catalogSources returns the configured sources, highest priority first.
+// Without a "sources" list in the configuration, or when --repository is
+// given explicitly, the single repository is used.
+func catalogSources() ([]catalogSource, error) {
+	var sources []catalogSource
+	if app == nil || !app.PersistentFlags().Changed("repository") {
+		err := viper.UnmarshalKey("sources", &sources)
+		if err != nil {
+			return nil, fmt.Errorf("invalid catalog sources in configuration: %w", err)
+		}
+	}
+	if len(sources) == 0 {
+		return []catalogSource{{Name: "community", Repository: viper.GetString("repository")}}, nil
+	}
+
+	seen := map[string]bool{}
+	for _, s := range sources {
+		if s.Name == "" || strings.Contains(s.Name, "/") {
+			return nil, fmt.Errorf("catalog source %q: name must be set and cannot contain '/'", s.Name)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("catalog source %q is defined more than once", s.Name)
+		}
+		seen[s.Name] = true
+		if (s.Repository == "") == (s.Path == "") {
+			return nil, fmt.Errorf("catalog source %q: exactly one of repository or path must be set", s.Name)
+		}
+	}
+	slices.SortStableFunc(sources, func(a, b catalogSource) int {
+		return b.Priority - a.Priority
+	})
+	return sources, nil
+}
+
+// findCatalogSource looks up a configured source by name.
+func findCatalogSource(name string) (catalogSource, error) {
+	sources, err := catalogSources()
+	if err != nil {
+		return catalogSource{}, err
+	}
+	for _, s := range sources {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return catalogSource{}, fmt.Errorf("unknown catalog source '%s'", name)
+}
+
+// splitAppRef splits "source/slug" into its parts. The source is empty for a bare slug.
+func splitAppRef(ref string) (string, string) {
+	source, slug, found := strings.Cut(ref, "/")
+	if !found {
+		return "", ref
+	}
+	return source, slug
+}
+
+// mergeCatalog adds apps from source to catalog, replacing existing slugs.
+func mergeCatalog(catalog map[string]Application, apps map[string]Application, source string) {
+	for k, v := range apps {
+		v.Source = source
+		catalog[k] = v
+	}
+}
+
+func getContainerCatalog() (map[string]Application, error) {
+	sources, err := catalogSources()
+	if err != nil {
+		return nil, err
+	}
+	catalog := map[string]Application{}
+	loaded := 0
+	// lowest priority first, so higher priorities override
+	for i := len(sources) - 1; i >= 0; i-- {
+		src := sources[i]
+		log.Debug("Downloading container catalog", "source", src.Name)
+		appJson, err := src.download("json", "ct-index.json")
+		if err != nil {
+			log.Warn("Failed to download container catalog", "source", src.Name, "error", err)
+			continue
+		}
+		var apps map[string]Application
+		err = json.Unmarshal(appJson, &apps)
+		if err != nil {
+			log.Warn("Failed to unmarshal container catalog", "source", src.Name, "error", err)
+			continue
+		}
+		mergeCatalog(catalog, apps, src.Name)
+		loaded++
+	}
+	if loaded == 0 {
+		return nil, errors.New("failed to load the container catalog from any source")
+	}
+	return catalog, nil
+}
+
+// getAppMetadata loads an application from the highest priority source that
+// has it. A "source/slug" reference only looks at the named source.
+func getAppMetadata(ref string) (*Application, error) {
+	sourceName, app := splitAppRef(ref)
+	if app == "" || strings.Contains(app, "/") || strings.Contains(app, "..") {
+		return nil, fmt.Errorf("invalid application %q", ref)
+	}
+	sources, err := catalogSources()
+	if err != nil {
+		return nil, err
+	}
+	if sourceName != "" {
+		src, err := findCatalogSource(sourceName)
+		if err != nil {
+			return nil, err
+		}
+		sources = []catalogSource{src}
+	}
+
+	for _, src := range sources {
+		log.Debug("Downloading application metadata", "application", app, "source", src.Name)
+		appJson, err := src.download("json", app+".json")
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Error("Failed to download application metadata:", "error", err)
+			return nil, err
+		}
+		var application Application
+		err = json.Unmarshal(appJson, &application)
+		if err != nil {
+			log.Error("Failed to parse application metadata:", "error", err)
+			return nil, err
+		}
+		application.Source = src.Name
+		return &application, nil
+	}
+	return nil, fmt.Errorf("app '%s' not found in catalog", ref)
+}
+
+// getMetadata loads the categories from the highest priority source that has them.
+func getMetadata() (*Metadata, error) {
+	sources, err := catalogSources()
+	if err != nil {
+		return nil, err
+	}
+	for _, src := range sources {
+		bb, err := src.download("json", metadataFile)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var m Metadata
+		err = json.Unmarshal(bb, &m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s from %s: %w", metadataFile, src.Name, err)
+		}
+		return &m, nil
+	}
+	return nil, fmt.Errorf("%s not found in any catalog source", metadataFile)
+}
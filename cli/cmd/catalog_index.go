@@ -0,0 +1,251 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+type cmdCatalog struct {
+	global *cmdGlobal
+}
+
+func (c *cmdCatalog) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "catalog"
+	cmd.Short = "maintain the application catalog"
+	cmd.Long =
+		`Maintain the application catalog
+
+These commands work on a checkout of the script repository and don't need an incus server.`
+
+	indexCmd := cmdCatalogIndex{global: c.global}
+	cmd.AddCommand(indexCmd.Command())
+
+	validateCmd := cmdCatalogValidate{global: c.global}
+	cmd.AddCommand(validateCmd.Command())
+
+	schemaCmd := cmdCatalogSchema{global: c.global}
+	cmd.AddCommand(schemaCmd.Command())
+
+	// Workaround for subcommand usage errors. See: https://github.com/spf13/cobra/issues/706
+	cmd.Args = cobra.NoArgs
+	cmd.Run = func(cmd *cobra.Command, args []string) { _ = cmd.Usage() }
+	return cmd
+}
+
+type cmdCatalogIndex struct {
+	global *cmdGlobal
+
+	flagDir string
+}
+
+func (c *cmdCatalogIndex) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "index"
+	cmd.Short = "generate the catalog index"
+	cmd.Args = cobra.NoArgs
+	cmd.Annotations = map[string]string{annotationNoIncus: "true"}
+	cmd.Long =
+		`Generate the catalog index
+
+Reads every application in json/*.json and writes json/index.json, holding the
+ct, vm and misc applications under separate keys together with the categories
+from json/metadata.json and a sha256 checksum of every script.
+
+json/ct-index.json, which only contains ct applications, is written as well for
+older versions of scripts-cli.
+
+Any malformed application file is reported and no index is written.`
+	cmd.Flags().StringVar(&c.flagDir, "dir", ".", "root of the script repository")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdCatalogIndex) Run(cmd *cobra.Command, args []string) error {
+	index, err := buildCatalogIndex(c.flagDir)
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONFile(filepath.Join(c.flagDir, "json", catalogIndexFile), index)
+	if err != nil {
+		return err
+	}
+	err = writeJSONFile(filepath.Join(c.flagDir, "json", legacyIndexFile), index.CT)
+	if err != nil {
+		return err
+	}
+	log.Info("Wrote catalog index", "ct", len(index.CT), "vm", len(index.VM), "misc", len(index.Misc))
+	return nil
+}
+
+const (
+	catalogIndexFile = "index.json"
+	legacyIndexFile  = "ct-index.json"
+	metadataFile     = "metadata.json"
+)
+
+// catalogIndex is the generated summary of every application in a repository.
+type catalogIndex struct {
+	Categories []Category             `json:"categories"`
+	CT         map[string]Application `json:"ct"`
+	VM         map[string]Application `json:"vm"`
+	Misc       map[string]Application `json:"misc"`
+	// Checksums maps each repository relative script path to its sha256 sum.
+	Checksums map[string]string `json:"checksums"`
+}
+
+// appFiles returns the application json files in dir, skipping the metadata and index files.
+func appFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "json", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(files, func(f string) bool {
+		name := filepath.Base(f)
+		return name == metadataFile || name == legacyIndexFile || name == catalogIndexFile
+	}), nil
+}
+
+// readApplication strictly decodes an application file, rejecting unknown fields.
+func readApplication(path string) (Application, error) {
+	var a Application
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return a, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(bb))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&a)
+	if err != nil {
+		return a, fmt.Errorf("%s: %w", path, err)
+	}
+	return a, nil
+}
+
+func readMetadata(dir string) (Metadata, error) {
+	var m Metadata
+	bb, err := os.ReadFile(filepath.Join(dir, "json", metadataFile))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(bb, &m)
+	if err != nil {
+		return m, fmt.Errorf("%s: %w", metadataFile, err)
+	}
+	return m, nil
+}
+
+func buildCatalogIndex(dir string) (*catalogIndex, error) {
+	metadata, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	index := &catalogIndex{
+		Categories: metadata.Categories,
+		CT:         map[string]Application{},
+		VM:         map[string]Application{},
+		Misc:       map[string]Application{},
+		Checksums:  map[string]string{},
+	}
+
+	files, err := appFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, f := range files {
+		a, err := readApplication(f)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		key := strings.TrimSuffix(filepath.Base(f), ".json")
+		switch a.Type {
+		case "ct":
+			index.CT[key] = a
+		case "vm":
+			index.VM[key] = a
+		case "misc":
+			index.Misc[key] = a
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown application type %q", f, a.Type))
+			continue
+		}
+
+		scripts := []string{}
+		for _, m := range a.InstallMethods {
+			scripts = append(scripts, m.Script)
+		}
+		installer := a.Installer()
+		if _, err := os.Stat(filepath.Join(dir, installer)); err == nil {
+			scripts = append(scripts, installer)
+		}
+		for _, s := range scripts {
+			sum, err := fileChecksum(filepath.Join(dir, s))
+			if errors.Is(err, fs.ErrNotExist) {
+				err = fmt.Errorf("%s: script %s does not exist", f, s)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			index.Checksums[filepath.ToSlash(s)] = sum
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return index, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bb)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func writeJSONFile(path string, v any) error {
+	bb, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bb, '\n'), 0o644)
+}
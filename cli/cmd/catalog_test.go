@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_splitAppRef(t *testing.T) {
+	tests := []struct {
+		name       string
+		ref        string
+		wantSource string
+		wantSlug   string
+	}{
+		{"bare", "grafana", "", "grafana"},
+		{"source", "internal/grafana", "internal", "grafana"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, slug := splitAppRef(tt.ref)
+			if source != tt.wantSource || slug != tt.wantSlug {
+				t.Errorf("splitAppRef() = %v, %v, want %v, %v", source, slug, tt.wantSource, tt.wantSlug)
+			}
+		})
+	}
+}
+
+// writeSource creates a directory catalog source holding the given json files.
+func writeSource(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "json"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(dir, "json", name), []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Test_catalogPrecedence(t *testing.T) {
+	community := writeSource(t, map[string]string{
+		"ct-index.json": `{"grafana": {"name": "Grafana", "slug": "grafana"}, "redis": {"name": "Redis", "slug": "redis"}}`,
+		"grafana.json":  `{"name": "Grafana", "slug": "grafana"}`,
+		"redis.json":    `{"name": "Redis", "slug": "redis"}`,
+	})
+	internal := writeSource(t, map[string]string{
+		"ct-index.json": `{"grafana": {"name": "Grafana (internal)", "slug": "grafana"}}`,
+		"grafana.json":  `{"name": "Grafana (internal)", "slug": "grafana"}`,
+	})
+	viper.Set("sources", []map[string]any{
+		{"name": "community", "path": community},
+		{"name": "internal", "path": internal, "priority": 10},
+	})
+	t.Cleanup(func() { viper.Set("sources", nil) })
+
+	catalog, err := getContainerCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := catalog["grafana"].Source; got != "internal" {
+		t.Errorf("catalog grafana source = %v, want internal", got)
+	}
+	if got := catalog["redis"].Source; got != "community" {
+		t.Errorf("catalog redis source = %v, want community", got)
+	}
+
+	tests := []struct {
+		ref        string
+		wantName   string
+		wantSource string
+		wantErr    bool
+	}{
+		{"grafana", "Grafana (internal)", "internal", false},
+		{"community/grafana", "Grafana", "community", false},
+		{"redis", "Redis", "community", false},
+		{"internal/redis", "", "", true},
+		{"missing/redis", "", "", true},
+		{"community/../../redis", "", "", true},
+		{"..", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := getAppMetadata(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getAppMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.wantName || got.Source != tt.wantSource {
+				t.Errorf("getAppMetadata() = %v from %v, want %v from %v", got.Name, got.Source, tt.wantName, tt.wantSource)
+			}
+		})
+	}
+}
+
+func Test_buildCatalogIndex(t *testing.T) {
+	appJSON := func(slug, typ string) string {
+		return `{"name": "` + slug + `", "slug": "` + slug + `", "type": "` + typ + `", "install_methods": [{"type": "default", "script": "ct/app.sh"}]}`
+	}
+	tests := []struct {
+		name     string
+		files    map[string]string
+		wantCT   int
+		wantVM   int
+		wantMisc int
+		wantErr  bool
+	}{
+		{"types", map[string]string{"a.json": appJSON("a", "ct"), "b.json": appJSON("b", "vm"), "c.json": appJSON("c", "misc")}, 1, 1, 1, false},
+		{"malformed", map[string]string{"a.json": appJSON("a", "ct"), "b.json": `{"name": `}, 0, 0, 0, true},
+		{"unknownField", map[string]string{"a.json": `{"name": "a", "slug": "a", "type": "ct", "colour": "red"}`}, 0, 0, 0, true},
+		{"unknownType", map[string]string{"a.json": appJSON("a", "pod")}, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.files["metadata.json"] = `{"categories": [{"name": "Misc", "id": 0}]}`
+			dir := writeSource(t, tt.files)
+			err := os.MkdirAll(filepath.Join(dir, "ct"), 0o755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(dir, "ct", "app.sh"), []byte("#!/bin/bash\n"), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := buildCatalogIndex(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildCatalogIndex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(got.CT) != tt.wantCT || len(got.VM) != tt.wantVM || len(got.Misc) != tt.wantMisc {
+				t.Errorf("buildCatalogIndex() = %d ct, %d vm, %d misc, want %d, %d, %d", len(got.CT), len(got.VM), len(got.Misc), tt.wantCT, tt.wantVM, tt.wantMisc)
+			}
+			if len(got.Categories) != 1 {
+				t.Errorf("buildCatalogIndex() categories = %v, want 1", len(got.Categories))
+			}
+			if _, ok := got.Checksums["ct/app.sh"]; !ok {
+				t.Errorf("buildCatalogIndex() missing checksum for ct/app.sh")
+			}
+		})
+	}
+}
+
+func Test_validateCatalog(t *testing.T) {
+	valid := map[string]any{
+		"name": "App", "slug": "app", "categories": []int{1}, "date_created": "2025-01-01", "type": "ct",
+		"updateable": false, "privileged": false, "interface_port": nil, "documentation": nil,
+		"website": "https://example.com", "logo": nil, "description": "An app.",
+		"install_methods": []map[string]any{{"type": "default", "script": "ct/app.sh", "resources": map[string]any{
+			"cpu": 1, "ram": 512, "hdd": 2, "os": "debian", "version": "12"}}},
+		"default_credentials": map[string]any{"username": nil, "password": nil},
+		"notes":               []any{},
+	}
+	with := func(key string, value any) map[string]any {
+		m := maps.Clone(valid)
+		m[key] = value
+		return m
+	}
+	tests := []struct {
+		name         string
+		app          map[string]any
+		installer    bool
+		aliases      map[string]bool
+		wantFindings int
+	}{
+		{"valid", valid, true, map[string]bool{"debian/12": true}, 0},
+		{"noInstaller", valid, false, nil, 1},
+		{"badDate", with("date_created", "01/01/2025"), true, nil, 1},
+		{"badType", with("type", "pod"), true, nil, 1},
+		{"unknownField", with("colour", "red"), true, nil, 1},
+		{"unknownCategory", with("categories", []int{99}), true, nil, 1},
+		{"missingScript", with("install_methods", []map[string]any{{"type": "default", "script": "ct/missing.sh", "resources": map[string]any{
+			"cpu": 1, "ram": 512, "hdd": 2, "os": "debian", "version": "12"}}}), true, nil, 1},
+		{"missingImage", valid, true, map[string]bool{"debian/11": true}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bb, err := json.Marshal(tt.app)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := writeSource(t, map[string]string{
+				"app.json":      string(bb),
+				"metadata.json": `{"categories": [{"name": "Misc", "id": 1}]}`,
+			})
+			for _, s := range []string{"ct/app.sh", "install/app-install.sh"} {
+				if s == "install/app-install.sh" && !tt.installer {
+					continue
+				}
+				err = os.MkdirAll(filepath.Join(dir, filepath.Dir(s)), 0o755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(filepath.Join(dir, s), []byte("#!/bin/bash\n"), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := validateCatalog(dir, tt.aliases)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.wantFindings {
+				t.Errorf("validateCatalog() = %v, want %d findings", got, tt.wantFindings)
+			}
+		})
+	}
+}
+
+func Test_normalizeOS(t *testing.T) {
+	tests := []struct {
+		name        string
+		os          string
+		version     string
+		wantOS      string
+		wantVersion string
+	}{
+		{"empty", "", "", "debian", "12"},
+		{"capitalized", "Debian", "12", "debian", "12"},
+		{"debianCodename", "debian", "bookworm", "debian", "12"},
+		{"ubuntuCodename", "ubuntu", "jammy", "ubuntu", "22.04"},
+		{"oracular", "ubuntu", "oracular", "ubuntu", "24.10"},
+		{"noVersion", "ubuntu", "", "ubuntu", "24.04"},
+		{"arch", "archlinux", "current", "archlinux", "current"},
+		{"spaces", " Alpine ", "3.21", "alpine", "3.21"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osName, version := normalizeOS(tt.os, tt.version)
+			if osName != tt.wantOS || version != tt.wantVersion {
+				t.Errorf("normalizeOS() = %v, %v, want %v, %v", osName, version, tt.wantOS, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func Test_osVersionCandidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		os      string
+		version string
+		want    []string
+	}{
+		{"debian", "debian", "11", []string{"11", "12", "13"}},
+		{"ubuntuInterim", "ubuntu", "24.10", []string{"24.10", "24.04"}},
+		{"alpine", "alpine", "3.21", []string{"3.21", "3.22", "3.23", "3.24"}},
+		{"arch", "archlinux", "current", []string{"current"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := osVersionCandidates(tt.os, tt.version); !slices.Equal(got, tt.want) {
+				t.Errorf("osVersionCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
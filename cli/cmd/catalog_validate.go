@@ -0,0 +1,281 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/spf13/cobra"
+)
+
+type cmdCatalogValidate struct {
+	global *cmdGlobal
+
+	flagDir         string
+	flagFormat      string
+	flagOffline     bool
+	flagImageServer string
+}
+
+func (c *cmdCatalogValidate) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "validate"
+	cmd.Short = "validate application files"
+	cmd.Args = cobra.NoArgs
+	cmd.Annotations = map[string]string{annotationNoIncus: "true"}
+	cmd.Long =
+		`Validate application files
+
+Checks every json/*.json against the application schema and the Application model, then
+checks that it is consistent with the rest of the repository:
+
+ - every install_methods[].script exists
+ - ct applications have an install/<slug>-install.sh script
+ - every category exists in json/metadata.json
+ - every OS and version pair maps to an image alias on the image server
+
+Use --format github to report problems as GitHub workflow annotations.`
+	cmd.Flags().StringVar(&c.flagDir, "dir", ".", "root of the script repository")
+	cmd.Flags().StringVar(&c.flagFormat, "format", "text", "output format (text or github)")
+	cmd.Flags().BoolVar(&c.flagOffline, "offline", false, "skip checks that need the image server")
+	cmd.Flags().StringVar(&c.flagImageServer, "image-server", "https://images.linuxcontainers.org", "simplestreams server used to check image aliases")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdCatalogValidate) Run(cmd *cobra.Command, args []string) error {
+	if c.flagFormat != "text" && c.flagFormat != "github" {
+		return fmt.Errorf("unknown format %q", c.flagFormat)
+	}
+
+	var aliases map[string]bool
+	if !c.flagOffline {
+		var err error
+		aliases, err = imageServerAliases(c.flagImageServer)
+		if err != nil {
+			return err
+		}
+	}
+
+	findings, err := validateCatalog(c.flagDir, aliases)
+	if err != nil {
+		return err
+	}
+
+	errorCount := 0
+	for _, f := range findings {
+		if f.Severity == severityError {
+			errorCount++
+		}
+		fmt.Println(f.format(c.flagFormat))
+	}
+	log.Info("Validated catalog", "errors", errorCount, "warnings", len(findings)-errorCount)
+	if errorCount > 0 {
+		return fmt.Errorf("catalog validation failed with %d errors", errorCount)
+	}
+	return nil
+}
+
+type cmdCatalogSchema struct {
+	global *cmdGlobal
+}
+
+func (c *cmdCatalogSchema) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "schema"
+	cmd.Short = "print the application JSON Schema"
+	cmd.Args = cobra.NoArgs
+	cmd.Annotations = map[string]string{annotationNoIncus: "true"}
+	cmd.Long =
+		`Print the application JSON Schema
+
+Prints the JSON Schema that catalog validate checks json/*.json against, for use in editors and other tools.`
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		_, err := os.Stdout.Write(applicationSchema)
+		return err
+	}
+
+	return cmd
+}
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// finding is a single validation problem in a catalog file.
+type finding struct {
+	File     string
+	Severity string
+	Message  string
+}
+
+func (f finding) format(format string) string {
+	if format == "github" {
+		escape := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+		return fmt.Sprintf("::%s file=%s,title=catalog validate::%s", f.Severity, f.File, escape.Replace(f.Message))
+	}
+	return fmt.Sprintf("%s: %s: %s", f.File, f.Severity, f.Message)
+}
+
+// imageServerAliases lists every image alias published by a simplestreams server.
+func imageServerAliases(server string) (map[string]bool, error) {
+	log.Debug("Loading image aliases", "server", server)
+	f, err := defaultFetcher()
+	if err != nil {
+		return nil, err
+	}
+	is, err := incus.ConnectSimpleStreams(server, &incus.ConnectionArgs{UserAgent: f.userAgent})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to image server: %w", err)
+	}
+	entries, err := is.GetImageAliases()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list image aliases: %w", err)
+	}
+	aliases := map[string]bool{}
+	for _, e := range entries {
+		aliases[e.Name] = true
+	}
+	return aliases, nil
+}
+
+// validateCatalog checks every application in dir. Image aliases are only
+// checked when aliases is not nil.
+func validateCatalog(dir string, aliases map[string]bool) ([]finding, error) {
+	schema, err := loadSchema(applicationSchema)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	categories := map[int]bool{}
+	for _, c := range metadata.Categories {
+		categories[c.ID] = true
+	}
+
+	files, err := appFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var findings []finding
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f)
+		if err != nil {
+			rel = f
+		}
+		rel = filepath.ToSlash(rel)
+		report := func(severity string, format string, args ...any) {
+			findings = append(findings, finding{File: rel, Severity: severity, Message: fmt.Sprintf(format, args...)})
+		}
+
+		bb, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var doc any
+		err = json.Unmarshal(bb, &doc)
+		if err != nil {
+			report(severityError, "invalid JSON: %v", err)
+			continue
+		}
+		schemaErrs := schema.validate("$", doc)
+		for _, msg := range schemaErrs {
+			report(severityError, "%s", msg)
+		}
+
+		a, err := readApplication(f)
+		if err != nil {
+			// the schema usually explains the same problem better
+			if len(schemaErrs) == 0 {
+				report(severityError, "does not match the Application model: %v", errors.Unwrap(err))
+			}
+			continue
+		}
+
+		if name := strings.TrimSuffix(filepath.Base(f), ".json"); name != a.GetSlug() {
+			report(severityWarning, "slug %q does not match the file name %q", a.Slug, name)
+		}
+		for _, m := range a.InstallMethods {
+			if m.Script != "" && !fileExists(filepath.Join(dir, m.Script)) {
+				report(severityError, "install method %q script %s does not exist", m.Type, m.Script)
+			}
+			switch {
+			case a.Type != "vm":
+			case m.VM == nil:
+				report(severityWarning, "install method %q has no vm metadata, launch cannot create it", m.Type)
+			case m.VM.Mode != vmModeCloudInit && m.VM.Image == nil:
+				report(severityError, "install method %q uses vm mode %s without an image", m.Type, m.VM.Mode)
+			}
+		}
+		if a.Type == "ct" {
+			if !fileExists(filepath.Join(dir, a.Installer())) {
+				report(severityError, "install script %s does not exist", a.Installer())
+			}
+		}
+		for _, id := range a.Categories {
+			if !categories[id] {
+				report(severityError, "category %d does not exist in %s", id, metadataFile)
+			}
+		}
+		for _, m := range a.InstallMethods {
+			if a.Type == "misc" || (a.Type == "vm" && (m.VM == nil || m.VM.Mode != vmModeCloudInit)) {
+				continue
+			}
+			osName, version := normalizeOS(m.Resources.OS, m.Resources.Version)
+			if m.Resources.OS != osName || m.Resources.Version != version {
+				report(severityWarning, "install method %q should use os %q and version %q", m.Type, osName, version)
+			}
+			if aliases == nil {
+				continue
+			}
+			candidates := osVersionCandidates(osName, version)
+			i := slices.IndexFunc(candidates, func(v string) bool { return aliases[osName+"/"+v] })
+			switch {
+			case i < 0:
+				report(severityError, "install method %q uses image %s/%s, which does not exist on the image server", m.Type, osName, version)
+			case i > 0:
+				report(severityWarning, "install method %q uses image %s/%s, which is end-of-life, launch falls back to %s/%s", m.Type, osName, version, osName, candidates[i])
+			}
+		}
+	}
+	slices.SortStableFunc(findings, func(a, b finding) int {
+		return strings.Compare(a.File, b.File)
+	})
+	return findings, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
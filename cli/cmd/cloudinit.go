@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	provisionAgent     = "agent"
+	provisionCloudInit = "cloud-init"
+)
+
+// cloudInit is the cloud-config user data given to a virtual machine.
+type cloudInit struct {
+	Timezone          string         `yaml:"timezone,omitempty"`
+	Locale            string         `yaml:"locale,omitempty"`
+	PackageUpdate     bool           `yaml:"package_update,omitempty"`
+	Packages          []string       `yaml:"packages,omitempty"`
+	DisableRoot       *bool          `yaml:"disable_root,omitempty"`
+	SSHPasswordAuth   *bool          `yaml:"ssh_pwauth,omitempty"`
+	SSHAuthorizedKeys []string       `yaml:"ssh_authorized_keys,omitempty"`
+	Chpasswd          *cloudChpasswd `yaml:"chpasswd,omitempty"`
+	WriteFiles        []cloudFile    `yaml:"write_files,omitempty"`
+	Commands          []string       `yaml:"runcmd,omitempty"`
+}
+
+type cloudChpasswd struct {
+	Expire bool        `yaml:"expire"`
+	Users  []cloudUser `yaml:"users"`
+}
+
+type cloudUser struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+	Type     string `yaml:"type"`
+}
+
+type cloudFile struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Permissions string `yaml:"permissions"`
+}
+
+// userData renders the cloud-config document.
+func (c cloudInit) userData() (string, error) {
+	if len(c.Packages) > 0 {
+		c.PackageUpdate = true
+	}
+	bb, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return "#cloud-config\n" + string(bb), nil
+}
+
+// applySSH gives root the SSH settings and password of a launch.
+func (c *cloudInit) applySSH(settings LaunchSettings) {
+	yes := true
+	no := false
+	c.DisableRoot = &no
+	if settings.SSHRootPassword {
+		c.SSHPasswordAuth = &yes
+	}
+	if key := strings.TrimSpace(settings.SSHAuthorizedKey); key != "" {
+		c.SSHAuthorizedKeys = []string{key}
+	}
+	if settings.RootPassword != "" {
+		c.Chpasswd = &cloudChpasswd{Users: []cloudUser{{Name: "root", Password: settings.RootPassword, Type: "text"}}}
+	}
+}
+
+// aptCacherFiles point apt at an apt-cacher-ng proxy while it is reachable
+// and straight at the mirrors when it is not, like install.func does.
+func aptCacherFiles(host string) []cloudFile {
+	detect := fmt.Sprintf(`#!/bin/bash
+if timeout 1 bash -c "</dev/tcp/%s/%s" 2>/dev/null; then
+  echo -n "http://%s"
+else
+  echo -n "DIRECT"
+fi
+`, host, aptCacherPort, net.JoinHostPort(host, aptCacherPort))
+	return []cloudFile{
+		{Path: "/usr/local/bin/apt-proxy-detect.sh", Content: detect, Permissions: "0755"},
+		{Path: "/etc/apt/apt.conf.d/00aptproxy", Content: "Acquire::http::Proxy-Auto-Detect \"/usr/local/bin/apt-proxy-detect.sh\";\n", Permissions: "0644"},
+	}
+}
+
+// installerCloudInit provisions a container application in a virtual machine
+// with cloud-init instead of running the installer over the agent. The
+// environment.* settings the installer reads are written to a file it sources.
+func installerCloudInit(settings LaunchSettings, config map[string]string, funcScript []byte, installScript []byte) cloudInit {
+	ci := cloudInit{
+		Timezone: config["environment.tz"],
+		Locale:   config["environment.LANG"],
+	}
+	ci.applySSH(settings)
+
+	var env strings.Builder
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if name, ok := strings.CutPrefix(k, "environment."); ok {
+			fmt.Fprintf(&env, "export %s=%s\n", name, shellQuote(config[k]))
+		}
+	}
+
+	ci.WriteFiles = []cloudFile{
+		{Path: "/install.func", Content: "#!/bin/env bash\n" + string(funcScript) + "\n", Permissions: "0755"},
+		{Path: "/root/.scriptcli-env", Content: env.String(), Permissions: "0600"},
+		{Path: "/root/scriptcli-install.sh", Content: installerScript(installScript), Permissions: "0700"},
+	}
+	if proxy := config["environment.http_proxy"]; proxy != "" {
+		ci.WriteFiles = append(ci.WriteFiles, cloudFile{Path: aptProxyFile, Content: aptProxyConfig(proxy), Permissions: "0644"})
+	}
+	ci.Commands = []string{
+		"bash -c '. /root/.scriptcli-env && bash /root/scriptcli-install.sh; rc=$?; rm -f /root/.scriptcli-env /root/scriptcli-install.sh; exit $rc'",
+	}
+	return ci
+}
+
+// installerScript prepares an install script to run outside of the Proxmox
+// helpers, which feed install.func through stdin.
+func installerScript(script []byte) string {
+	return strings.ReplaceAll(string(script), "/dev/stdin <<<", "")
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// waitCloudInit waits for cloud-init to finish in a virtual machine, and shows
+// the cloud-init output when it fails or does not finish before the timeout.
+func waitCloudInit(server incus.InstanceServer, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	err := waitAgent(server, name, deadline)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Waiting for cloud-init to finish", "instance", name, "timeout", timeout)
+	type result struct {
+		ret int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ret, err := instanceExec(server, name, []string{"cloud-init", "status", "--wait"}, nil, nil, nil)
+		done <- result{ret, err}
+	}()
+
+	select {
+	case r := <-done:
+		switch {
+		case r.err != nil:
+			err = r.err
+		// 2 means cloud-init finished with recoverable errors
+		case r.ret == 1:
+			err = errors.New("cloud-init failed")
+		case r.ret == 2:
+			log.Warn("cloud-init finished with recoverable errors", "instance", name)
+		}
+	case <-time.After(time.Until(deadline)):
+		err = fmt.Errorf("cloud-init did not finish within %s", timeout)
+	}
+	if err != nil {
+		showCloudInitLogs(server, name)
+		return fmt.Errorf("provisioning %s: %w", name, err)
+	}
+	log.Info("cloud-init finished", "instance", name)
+	return nil
+}
+
+// waitAgent waits until commands can be run in a virtual machine.
+func waitAgent(server incus.InstanceServer, name string, deadline time.Time) error {
+	log.Info("Waiting for the VM agent", "instance", name)
+	for {
+		ret, err := instanceExec(server, name, []string{"true"}, nil, nil, nil)
+		if err == nil && ret == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the agent in %s did not start: %w", name, err)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// showCloudInitLogs prints the end of the cloud-init logs of an instance.
+func showCloudInitLogs(server incus.InstanceServer, name string) {
+	fmt.Fprintf(os.Stderr, "\n--- cloud-init status of %s ---\n", name)
+	_, _ = instanceExec(server, name, []string{"cloud-init", "status", "--long"}, nil, os.Stderr, os.Stderr)
+	fmt.Fprintf(os.Stderr, "\n--- /var/log/cloud-init-output.log ---\n")
+	_, err := instanceExec(server, name, []string{"tail", "-n", "50", "/var/log/cloud-init-output.log"}, nil, os.Stderr, os.Stderr)
+	if err != nil {
+		log.Warn("Failed to read the cloud-init logs", "instance", name, "error", err)
+	}
+}
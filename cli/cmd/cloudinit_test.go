@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func Test_shellQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "grafana", `'grafana'`},
+		{"empty", "", `''`},
+		{"quote", "it's", `'it'\''s'`},
+		{"expansion", "$HOME `id`", `'$HOME ` + "`id`" + `'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shellQuote(tt.s); got != tt.want {
+				t.Errorf("shellQuote() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_installerCloudInit(t *testing.T) {
+	tests := []struct {
+		name         string
+		settings     LaunchSettings
+		wantPassword bool
+		wantKeys     int
+	}{
+		{"defaults", LaunchSettings{Name: "app"}, false, 0},
+		{"credentials", LaunchSettings{Name: "app", RootPassword: "secret", SSHAuthorizedKey: "ssh-ed25519 AAAA user\n"}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]string{
+				"environment.tz":       "Europe/Berlin",
+				"environment.PASSWORD": tt.settings.RootPassword,
+				"security.nesting":     "true",
+			}
+			ci := installerCloudInit(tt.settings, config, []byte("msg_info() { :; }"), []byte("source /dev/stdin <<<\"$FUNCTIONS_FILE_PATH\"\n"))
+			userData, err := ci.userData()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(userData, "#cloud-config\n") {
+				t.Errorf("userData() does not start with #cloud-config")
+			}
+			var got cloudInit
+			err = yaml.Unmarshal([]byte(userData), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Timezone != "Europe/Berlin" {
+				t.Errorf("timezone = %v, want Europe/Berlin", got.Timezone)
+			}
+			if (got.Chpasswd != nil) != tt.wantPassword {
+				t.Errorf("chpasswd = %v, want password %v", got.Chpasswd, tt.wantPassword)
+			}
+			if len(got.SSHAuthorizedKeys) != tt.wantKeys {
+				t.Errorf("ssh_authorized_keys = %v, want %d keys", got.SSHAuthorizedKeys, tt.wantKeys)
+			}
+			if len(got.WriteFiles) != 3 {
+				t.Fatalf("write_files = %d files, want 3", len(got.WriteFiles))
+			}
+			if env := got.WriteFiles[1].Content; !strings.Contains(env, "export tz='Europe/Berlin'") || strings.Contains(env, "nesting") {
+				t.Errorf("environment file = %q", env)
+			}
+			if strings.Contains(got.WriteFiles[2].Content, "/dev/stdin") {
+				t.Errorf("install script still reads install.func from stdin")
+			}
+		})
+	}
+}
+
+func Test_aptCacherFiles(t *testing.T) {
+	files := aptCacherFiles("192.168.1.10")
+	if len(files) != 2 {
+		t.Fatalf("aptCacherFiles() = %d files, want 2", len(files))
+	}
+	detect := files[0].Content
+	for _, want := range []string{"</dev/tcp/192.168.1.10/3142", `echo -n "http://192.168.1.10:3142"`, `echo -n "DIRECT"`} {
+		if !strings.Contains(detect, want) {
+			t.Errorf("apt-proxy-detect.sh = %q, want it to contain %q", detect, want)
+		}
+	}
+	if !strings.Contains(files[1].Content, "Proxy-Auto-Detect") {
+		t.Errorf("apt configuration = %q, want Proxy-Auto-Detect", files[1].Content)
+	}
+}
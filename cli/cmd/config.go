@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var configFile string
+
+// initConfig loads the optional scripts-cli configuration file. Every key can
+// also be set with a SCRIPTS_CLI_ prefixed environment variable.
+func initConfig() {
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName("config")
+		viper.SetConfigType("yaml")
+		viper.AddConfigPath(configDir())
+	}
+	viper.SetEnvPrefix("SCRIPTS_CLI")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+
+	err := viper.ReadInConfig()
+	var notFound viper.ConfigFileNotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		log.Warn("Failed to read configuration", "error", err)
+		return
+	}
+	log.Debug("Loaded configuration", "file", viper.ConfigFileUsed())
+}
+
+// configDir is the scripts-cli directory below the user configuration directory.
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(dir, "scripts-cli")
+}
+
+// configFilePath is the configuration file in use, or where a new one is created.
+func configFilePath() string {
+	if f := viper.ConfigFileUsed(); f != "" {
+		return f
+	}
+	return filepath.Join(configDir(), "config.yaml")
+}
+
+// updateConfigFile changes the configuration file in place. Unlike
+// viper.WriteConfig it only writes the keys in the file, not the defaults and
+// flags merged into the running configuration.
+func updateConfigFile(update func(settings map[string]any)) error {
+	path := configFilePath()
+	settings := map[string]any{}
+	bb, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		err = yaml.Unmarshal(bb, &settings)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	update(settings)
+
+	bb, err = yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	log.Debug("Writing configuration", "file", path)
+	return os.WriteFile(path, bb, 0o600)
+}
+
+// settingsMap returns the nested map stored under key, creating it when needed.
+func settingsMap(settings map[string]any, key string) map[string]any {
+	m, ok := settings[key].(map[string]any)
+	if !ok {
+		m = map[string]any{}
+		settings[key] = m
+	}
+	return m
+}
@@ -0,0 +1,357 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/spf13/cobra"
+)
+
+type cmdDevices struct {
+	global *cmdGlobal
+}
+
+func (c *cmdDevices) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "devices"
+	cmd.Short = "pass host devices through to instances"
+	cmd.Long =
+		`Pass host devices through to instances
+
+USB devices are listed from the incus server, serial devices from /dev/serial/by-id
+on the host running scripts-cli.`
+
+	addCmd := cmdDevicesAdd{global: c.global}
+	cmd.AddCommand(addCmd.Command())
+
+	// Workaround for subcommand usage errors. See: https://github.com/spf13/cobra/issues/706
+	cmd.Args = cobra.NoArgs
+	cmd.Run = func(cmd *cobra.Command, args []string) { _ = cmd.Usage() }
+	return cmd
+}
+
+type cmdDevicesAdd struct {
+	global *cmdGlobal
+
+	flagUSB    []string
+	flagSerial []string
+}
+
+func (c *cmdDevicesAdd) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "add <instance>"
+	cmd.Short = "attach USB or serial devices to an instance"
+	cmd.Args = cobra.ExactArgs(1)
+	cmd.Long =
+		`Attach USB or serial devices to an instance
+
+USB devices are chosen by vendor:product ID or serial number, serial devices by their
+name in /dev/serial/by-id. Without flags the host devices are offered in a list.
+
+Serial devices keep their /dev/serial/by-id path in containers and belong to the
+dialout group of the container. VMs can only take USB devices. Running instances get
+the devices immediately.`
+	cmd.Example = `  scripts-cli devices add zigbee2mqtt --serial usb-ITead_Sonoff_Zigbee_3.0_USB_Dongle_Plus-if00-port0
+  scripts-cli devices add homeassistant --usb 0658:0200`
+	cmd.Flags().StringSliceVar(&c.flagUSB, "usb", nil, "USB devices to attach, by vendor:product ID or serial number")
+	cmd.Flags().StringSliceVar(&c.flagSerial, "serial", nil, "serial devices to attach, by name in /dev/serial/by-id")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdDevicesAdd) Run(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	inst, _, err := server.GetInstance(name)
+	if err != nil {
+		return fmt.Errorf("failed to get instance %s: %w", name, err)
+	}
+	if len(c.flagSerial) > 0 && !c.global.localServer() {
+		return errRemoteSerial
+	}
+	available, err := c.global.hostDevices()
+	if err != nil {
+		return err
+	}
+
+	var selected []hostDevice
+	if len(c.flagUSB) == 0 && len(c.flagSerial) == 0 {
+		accessible, _ := strconv.ParseBool(os.Getenv("ACCESSIBLE"))
+		selected, err = devicesForm(available, nil, accessible)
+		if err != nil {
+			return err
+		}
+	} else {
+		selected, err = selectHostDevices(available, c.flagUSB, c.flagSerial)
+		if err != nil {
+			return err
+		}
+	}
+	if len(selected) == 0 {
+		log.Info("No devices selected")
+		return nil
+	}
+	return attachDevices(server, name, inst.Type == string(api.InstanceTypeVM), selected)
+}
+
+const (
+	deviceUSB    = "usb"
+	deviceSerial = "serial"
+)
+
+// serialByID holds the stable names of the serial devices of the host.
+const serialByID = "/dev/serial/by-id"
+
+// errRemoteSerial is returned for serial devices on remote servers, whose
+// /dev cannot be listed.
+var errRemoteSerial = errors.New("serial devices can only be passed through on a local incus server, pass through their USB device instead")
+
+// deviceCategories are the catalog categories offered device passthrough by
+// default: IoT & Smart Home and ZigBee, Z-Wave & Matter.
+var deviceCategories = []int{16, 17}
+
+// hostDevice is a USB or serial device of the host.
+type hostDevice struct {
+	Kind        string
+	Description string
+	// VendorID, ProductID and Serial identify USB devices.
+	VendorID  string
+	ProductID string
+	Serial    string
+	// Path is the /dev/serial/by-id path of serial devices.
+	Path string
+}
+
+// ID is how a device is chosen on the command line.
+func (d hostDevice) ID() string {
+	if d.Kind == deviceSerial {
+		return filepath.Base(d.Path)
+	}
+	return d.VendorID + ":" + d.ProductID
+}
+
+func (d hostDevice) String() string {
+	if d.Description == "" {
+		return fmt.Sprintf("%s %s", d.Kind, d.ID())
+	}
+	return fmt.Sprintf("%s %s (%s)", d.Kind, d.ID(), d.Description)
+}
+
+var deviceNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// DeviceName is the incus device name of a passed through device. USB
+// devices with a serial number include it, as sticks of the same model share
+// their vendor:product ID.
+func (d hostDevice) DeviceName() string {
+	id := d.ID()
+	if d.Kind == deviceUSB && d.Serial != "" {
+		id += "-" + d.Serial
+	}
+	name := d.Kind + "-" + deviceNameUnsafe.ReplaceAllString(id, "-")
+	return strings.Trim(name, "-")
+}
+
+// wantsDevices reports whether an application is offered device passthrough
+// by default.
+func wantsDevices(app Application) bool {
+	for _, c := range app.Categories {
+		if slices.Contains(deviceCategories, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostDevices lists the USB devices of the server and, when the server is
+// local, its serial devices.
+func (c *cmdGlobal) hostDevices() ([]hostDevice, error) {
+	server, err := c.server()
+	if err != nil {
+		return nil, err
+	}
+	res, err := server.GetServerResources()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server resources: %w", err)
+	}
+	var devices []hostDevice
+	for _, u := range res.USB.Devices {
+		if slices.ContainsFunc(u.Interfaces, func(i api.ResourcesUSBDeviceInterface) bool { return i.Class == "Hub" }) {
+			continue
+		}
+		devices = append(devices, hostDevice{
+			Kind:        deviceUSB,
+			Description: strings.TrimSpace(u.Vendor + " " + u.Product),
+			VendorID:    u.VendorID,
+			ProductID:   u.ProductID,
+			Serial:      u.Serial,
+		})
+	}
+
+	if !c.localServer() {
+		return devices, nil
+	}
+	entries, err := os.ReadDir(serialByID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		devices = append(devices, hostDevice{Kind: deviceSerial, Path: filepath.Join(serialByID, e.Name())})
+	}
+	return devices, nil
+}
+
+// selectHostDevices finds the USB devices by vendor:product ID or serial
+// number and the serial devices by name or path.
+func selectHostDevices(devices []hostDevice, usb []string, serial []string) ([]hostDevice, error) {
+	var selected []hostDevice
+	for _, value := range usb {
+		var matches []hostDevice
+		for _, d := range devices {
+			if d.Kind == deviceUSB && (strings.EqualFold(d.ID(), value) || (d.Serial != "" && d.Serial == value)) {
+				matches = append(matches, d)
+			}
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no USB device matches %q, use the vendor:product ID or serial number", value)
+		}
+		// devices without serial numbers cannot be told apart, incus passes all of them through
+		if len(matches) > 1 && slices.ContainsFunc(matches, func(d hostDevice) bool { return d.Serial != "" }) {
+			return nil, fmt.Errorf("%d USB devices match %q, choose one by serial number", len(matches), value)
+		}
+		selected = append(selected, matches[0])
+	}
+	for _, value := range serial {
+		i := slices.IndexFunc(devices, func(d hostDevice) bool {
+			return d.Kind == deviceSerial && (d.Path == value || d.ID() == value)
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("no serial device %q in %s", value, serialByID)
+		}
+		selected = append(selected, devices[i])
+	}
+	return selected, nil
+}
+
+// passthroughDevice is the incus device for a host device. USB devices are
+// matched by ID so they survive being plugged into another port. Serial
+// devices keep their by-id path, which is what the applications are
+// configured with.
+func passthroughDevice(d hostDevice, gid string) map[string]string {
+	var device map[string]string
+	if d.Kind == deviceSerial {
+		device = map[string]string{"type": "unix-char", "source": d.Path, "path": d.Path}
+	} else {
+		device = map[string]string{"type": "usb", "vendorid": d.VendorID, "productid": d.ProductID}
+		if d.Serial != "" {
+			device["serial"] = d.Serial
+		}
+	}
+	if gid != "" {
+		device["gid"] = gid
+		device["mode"] = "0660"
+	}
+	return device
+}
+
+// passthroughDevices builds the incus devices for host devices passed
+// through to an instance, owned by the dialout group in containers.
+func passthroughDevices(server incus.InstanceServer, name string, vm bool, devices []hostDevice) (map[string]map[string]string, error) {
+	result := map[string]map[string]string{}
+	if len(devices) == 0 {
+		return result, nil
+	}
+	gid := ""
+	if !vm {
+		var err error
+		gid, err = instanceGroupID(server, name, "dialout")
+		if err != nil {
+			log.Warn("Devices are owned by root", "error", err)
+		}
+	}
+	for _, d := range devices {
+		if vm && d.Kind == deviceSerial {
+			return nil, fmt.Errorf("%s cannot be attached to a VM, attach its USB device instead", d.Path)
+		}
+		result[d.DeviceName()] = passthroughDevice(d, gid)
+		log.Info("Attaching device", "instance", name, "device", d.String())
+	}
+	return result, nil
+}
+
+// attachDevices attaches host devices to an instance. Running instances get
+// the devices immediately.
+func attachDevices(server incus.InstanceServer, name string, vm bool, devices []hostDevice) error {
+	result, err := passthroughDevices(server, name, vm, devices)
+	if err != nil {
+		return err
+	}
+	return addInstanceDevices(server, name, result)
+}
+
+// devicesForm asks which host devices to pass through.
+func devicesForm(devices []hostDevice, selected []hostDevice, accessible bool) ([]hostDevice, error) {
+	if len(devices) == 0 {
+		log.Warn("The host has no USB or serial devices")
+		return nil, nil
+	}
+	options := make([]huh.Option[string], len(devices))
+	for i, d := range devices {
+		options[i] = huh.NewOption(d.String(), d.DeviceName()).Selected(slices.Contains(selected, d))
+	}
+	var names []string
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewMultiSelect[string]().
+				Options(options...).
+				Title("Select USB and Serial Devices").
+				Value(&names).
+				Description("Devices to pass through to the instance."),
+		),
+	).WithAccessible(accessible)
+	err := form.Run()
+	if err != nil {
+		return nil, err
+	}
+	var chosen []hostDevice
+	for _, d := range devices {
+		if slices.Contains(names, d.DeviceName()) {
+			chosen = append(chosen, d)
+		}
+	}
+	return chosen, nil
+}
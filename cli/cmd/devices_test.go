@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_selectHostDevices(t *testing.T) {
+	zwave := hostDevice{Kind: deviceUSB, Description: "Sigma Designs Aeotec Z-Stick", VendorID: "0658", ProductID: "0200"}
+	sonoff := hostDevice{Kind: deviceUSB, VendorID: "10c4", ProductID: "ea60", Serial: "9c1b2a"}
+	zigbee := hostDevice{Kind: deviceUSB, VendorID: "10c4", ProductID: "ea60", Serial: "0001"}
+	serial := hostDevice{Kind: deviceSerial, Path: "/dev/serial/by-id/usb-ITead_Sonoff-if00-port0"}
+	devices := []hostDevice{zwave, sonoff, zigbee, serial}
+	tests := []struct {
+		name    string
+		usb     []string
+		serial  []string
+		want    []hostDevice
+		wantErr bool
+	}{
+		{"usb by id", []string{"0658:0200"}, nil, []hostDevice{zwave}, false},
+		{"usb by serial number", []string{"9c1b2a"}, nil, []hostDevice{sonoff}, false},
+		{"ambiguous usb id", []string{"10c4:ea60"}, nil, nil, true},
+		{"serial by name", nil, []string{"usb-ITead_Sonoff-if00-port0"}, []hostDevice{serial}, false},
+		{"serial by path", nil, []string{"/dev/serial/by-id/usb-ITead_Sonoff-if00-port0"}, []hostDevice{serial}, false},
+		{"missing usb", []string{"1234:5678"}, nil, nil, true},
+		{"missing serial", nil, []string{"ttyACM0"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectHostDevices(devices, tt.usb, tt.serial)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectHostDevices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectHostDevices() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_passthroughDevice(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   hostDevice
+		gid      string
+		wantName string
+		want     map[string]string
+	}{
+		{
+			"usb", hostDevice{Kind: deviceUSB, VendorID: "0658", ProductID: "0200"}, "20",
+			"usb-0658-0200", map[string]string{"type": "usb", "vendorid": "0658", "productid": "0200", "gid": "20", "mode": "0660"},
+		},
+		{
+			"usb with serial number", hostDevice{Kind: deviceUSB, VendorID: "10c4", ProductID: "ea60", Serial: "9c1b2a"}, "",
+			"usb-10c4-ea60-9c1b2a", map[string]string{"type": "usb", "vendorid": "10c4", "productid": "ea60", "serial": "9c1b2a"},
+		},
+		{
+			"serial", hostDevice{Kind: deviceSerial, Path: "/dev/serial/by-id/usb-ITead_Sonoff-if00-port0"}, "",
+			"serial-usb-ITead-Sonoff-if00-port0", map[string]string{"type": "unix-char", "source": "/dev/serial/by-id/usb-ITead_Sonoff-if00-port0", "path": "/dev/serial/by-id/usb-ITead_Sonoff-if00-port0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.device.DeviceName(); got != tt.wantName {
+				t.Errorf("DeviceName() = %v, want %v", got, tt.wantName)
+			}
+			if got := passthroughDevice(tt.device, tt.gid); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("passthroughDevice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
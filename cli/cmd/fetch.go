@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+)
+
+// errNotFound is matched by errors.Is for any download that returned 404.
+var errNotFound = errors.New("not found")
+
+// httpStatusError is returned when a server answers with a non-200 status.
+type httpStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	retryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	switch {
+	case e.StatusCode == http.StatusNotFound:
+		return fmt.Sprintf("%s: not found", e.URL)
+	case e.StatusCode == http.StatusTooManyRequests:
+		return fmt.Sprintf("%s: rate limited by server, try again later", e.URL)
+	case e.StatusCode == http.StatusForbidden:
+		return fmt.Sprintf("%s: access denied (%s)", e.URL, e.Status)
+	case e.StatusCode >= 500:
+		return fmt.Sprintf("%s: server error (%s)", e.URL, e.Status)
+	}
+	return fmt.Sprintf("%s: unexpected response (%s)", e.URL, e.Status)
+}
+
+func (e *httpStatusError) Is(target error) bool {
+	return target == errNotFound && e.StatusCode == http.StatusNotFound
+}
+
+func (e *httpStatusError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// fetcher is the shared HTTP layer used for every catalog, script and image download.
+type fetcher struct {
+	client    *http.Client
+	userAgent string
+	timeout   time.Duration
+	retries   int
+	backoff   time.Duration
+}
+
+// defaultFetcher builds the fetcher from the global flags on first use.
+var defaultFetcher = sync.OnceValues(func() (*fetcher, error) {
+	return newFetcher(viper.GetDuration("http-timeout"), viper.GetInt("http-retries"), viper.GetString("ca-bundle"))
+})
+
+func newFetcher(timeout time.Duration, retries int, caBundle string) (*fetcher, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.ResponseHeaderTimeout = timeout
+
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	ua := "scripts-cli/" + bversion.GitVersion
+	if bversion.GitVersion == "" {
+		ua = "scripts-cli/dev"
+	}
+
+	return &fetcher{
+		client:    &http.Client{Transport: transport},
+		userAgent: ua,
+		timeout:   timeout,
+		retries:   retries,
+		backoff:   time.Second,
+	}, nil
+}
+
+// fetch downloads url into memory. Each attempt is bounded by the fetcher timeout.
+func (f *fetcher) fetch(ctx context.Context, url string) ([]byte, error) {
+	var bb []byte
+	err := f.retry(ctx, url, func(ctx context.Context) error {
+		if f.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, f.timeout)
+			defer cancel()
+		}
+		resp, err := f.get(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		bb, err = io.ReadAll(resp.Body)
+		return err
+	})
+	return bb, err
+}
+
+// fetchFile streams url to path, reporting progress on a terminal. Large
+// downloads are only bounded by ctx, the fetcher timeout applies to the
+// response headers.
+func (f *fetcher) fetchFile(ctx context.Context, url string, path string, label string) error {
+	return f.retry(ctx, url, func(ctx context.Context) error {
+		resp, err := f.get(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		out, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		var w io.Writer = out
+		if isTerminal(os.Stderr) {
+			p := &progressWriter{label: label, total: resp.ContentLength}
+			defer p.done()
+			w = io.MultiWriter(out, p)
+		}
+		_, err = io.Copy(w, resp.Body)
+		return err
+	})
+}
+
+func (f *fetcher) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, describeTransportError(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &httpStatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status, retryAfter: retryAfter(resp)}
+	}
+	return resp, nil
+}
+
+// retry runs attempt until it succeeds, fails permanently or runs out of retries,
+// backing off exponentially between attempts.
+func (f *fetcher) retry(ctx context.Context, url string, attempt func(context.Context) error) error {
+	for i := 0; ; i++ {
+		err := attempt(ctx)
+		if err == nil {
+			return nil
+		}
+		if i >= f.retries || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+
+		wait := f.backoff << i
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.retryAfter > wait {
+			wait = statusErr.retryAfter
+		}
+		log.Debug("Retrying download", "url", url, "attempt", i+1, "wait", wait, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// isRetryable reports whether a failed download may succeed when repeated:
+// 5xx and 429 responses, timeouts and dropped connections. Certificate
+// errors, unknown hosts and refused connections fail right away.
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.retryable()
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// describeTransportError adds a hint for the failures users can fix themselves.
+func describeTransportError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		return fmt.Errorf("%w (use --ca-bundle to trust a custom certificate authority)", err)
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return fmt.Errorf("unable to resolve %s: %w", dnsErr.Name, err)
+	}
+	return err
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressWriter prints a single updating progress line to stderr.
+type progressWriter struct {
+	label   string
+	total   int64
+	written int64
+	last    time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if time.Since(p.last) > 200*time.Millisecond {
+		p.last = time.Now()
+		p.print()
+	}
+	return len(b), nil
+}
+
+func (p *progressWriter) print() {
+	if p.total > 0 {
+		fmt.Fprintf(os.Stderr, "\r%s: %3d%% (%s / %s)", p.label, p.written*100/p.total, formatBytes(p.written), formatBytes(p.total))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\r%s: %s", p.label, formatBytes(p.written))
+}
+
+func (p *progressWriter) done() {
+	p.print()
+	fmt.Fprintln(os.Stderr)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
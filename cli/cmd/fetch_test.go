@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_fetcher_fetch(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		want      string
+		wantCalls int
+		wantErr   error
+	}{
+		{"ok", []int{200}, "body", 1, nil},
+		{"retry5xx", []int{503, 502, 200}, "body", 3, nil},
+		{"retry429", []int{429, 200}, "body", 2, nil},
+		{"notFound", []int{404}, "", 1, errNotFound},
+		{"exhausted", []int{500, 500, 500, 500}, "", 4, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if ua := r.Header.Get("User-Agent"); ua != "scripts-cli/test" {
+					t.Errorf("User-Agent = %q, want %q", ua, "scripts-cli/test")
+				}
+				status := tt.statuses[calls]
+				calls++
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					w.Write([]byte("body"))
+				}
+			}))
+			defer srv.Close()
+
+			f, err := newFetcher(time.Second, 3, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.userAgent = "scripts-cli/test"
+			f.backoff = time.Millisecond
+
+			got, err := f.fetch(context.Background(), srv.URL)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("fetch() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want == "" && err == nil {
+				t.Errorf("fetch() expected an error")
+			}
+			if string(got) != tt.want {
+				t.Errorf("fetch() = %q, want %q", got, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("fetch() made %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func Test_isRetryable(t *testing.T) {
+	wrap := func(err error) error { return &url.Error{Op: "Get", URL: "https://example.com", Err: err} }
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &httpStatusError{StatusCode: http.StatusBadGateway}, true},
+		{"rate limited", &httpStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"client error", &httpStatusError{StatusCode: http.StatusForbidden}, false},
+		{"timeout", wrap(os.ErrDeadlineExceeded), true},
+		{"connection reset", wrap(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"unexpected eof", wrap(io.ErrUnexpectedEOF), true},
+		{"connection refused", wrap(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), false},
+		{"unknown host", wrap(&net.OpError{Op: "dial", Err: &net.DNSError{Name: "example.com", IsNotFound: true}}), false},
+		{"dns timeout", wrap(&net.DNSError{Name: "example.com", IsTimeout: true}), true},
+		{"certificate", wrap(x509.UnknownAuthorityError{}), false},
+		{"other", fmt.Errorf("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
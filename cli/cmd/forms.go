@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/charmbracelet/huh"
 )
@@ -69,3 +72,83 @@ func vmForm(accessible bool) (bool, error) {
 	}
 	return vm, nil
 }
+
+// sshForm asks whether to enable SSH and, if so, for root access with a
+// password and an authorized key.
+func sshForm(settings *LaunchSettings, accessible bool) error {
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("Enable SSH?").
+				Value(&settings.EnableSSH).
+				Affirmative("Yes").
+				Negative("No"),
+		),
+	).WithAccessible(accessible)
+	err := form.Run()
+	if err != nil {
+		return err
+	}
+	if !settings.EnableSSH {
+		return nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	var rootPasswordTwice string
+	authKeyFile := ""
+	form = huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("Allow Root SSH with Password?").
+				Value(&settings.SSHRootPassword).
+				Affirmative("Yes").
+				Negative("No"),
+		),
+		huh.NewGroup(
+			huh.NewInput().
+				Value(&settings.RootPassword).
+				Title("Enter Root Password").
+				Placeholder("correct-horse-battery-staple").
+				EchoMode(huh.EchoModePassword).
+				Description("Root password for the instance."),
+			huh.NewInput().
+				Value(&rootPasswordTwice).
+				Title("Confirm Root Password").
+				Placeholder("correct-horse-battery-staple").
+				EchoMode(huh.EchoModePassword).
+				Description("Root password for the instance.").
+				Validate(func(s string) error {
+					if s != settings.RootPassword {
+						return errors.New("passwords do not match")
+					}
+					return nil
+				}),
+		),
+		huh.NewGroup(
+			huh.NewFilePicker().
+				Value(&authKeyFile).
+				Title("SSH Authorized Key").
+				FileAllowed(true).
+				DirAllowed(false).
+				AllowedTypes([]string{".pub"}).
+				ShowHidden(true).
+				ShowSize(false).
+				ShowPermissions(false).
+				CurrentDirectory(filepath.Join(home, ".ssh")).
+				Description("Press enter to choose a public key file."),
+		),
+	).WithAccessible(accessible)
+	err = form.Run()
+	if err != nil {
+		return err
+	}
+	bb, err := os.ReadFile(authKeyFile)
+	if err != nil {
+		return fmt.Errorf("error reading pub key: %w", err)
+	}
+	settings.SSHAuthorizedKey = string(bb)
+	return nil
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/bketelsen/inclient"
+	incus "github.com/lxc/incus/v6/client"
 	config "github.com/lxc/incus/v6/shared/cliconfig"
 	"github.com/spf13/cobra"
 )
 
+// annotationNoIncus marks commands that run without connecting to incus.
+const annotationNoIncus = "scripts-cli/no-incus"
+
 type cmdGlobal struct {
 	cmd    *cobra.Command
 	conf   *config.Config
@@ -17,3 +23,15 @@ type cmdGlobal struct {
 
 	flagHelpAll bool
 }
+
+// server returns the instance server of the default remote.
+func (c *cmdGlobal) server() (incus.InstanceServer, error) {
+	return c.conf.GetInstanceServer(c.conf.DefaultRemote)
+}
+
+// localServer reports whether the default remote is the incus server of this
+// host, reached over its unix socket.
+func (c *cmdGlobal) localServer() bool {
+	remote, ok := c.conf.Remotes[c.conf.DefaultRemote]
+	return ok && strings.HasPrefix(remote.Addr, "unix:")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+)
+
+// gpuCategories are the catalog categories offered GPU passthrough by
+// default: Media & Streaming and NVR & Cameras transcode video.
+var gpuCategories = []int{13, 15}
+
+// anyGPU selects the first GPU of the host.
+const anyGPU = "any"
+
+// hostGPU is a GPU of the incus server.
+type hostGPU struct {
+	PCIAddress string
+	Vendor     string
+	VendorID   string
+	Product    string
+	Driver     string
+	Nvidia     bool
+	// Mdev lists the mediated device profiles with free instances, for VMs.
+	Mdev []string
+}
+
+func (g hostGPU) String() string {
+	name := strings.TrimSpace(g.Vendor + " " + g.Product)
+	if name == "" {
+		name = "GPU"
+	}
+	if g.Driver != "" {
+		return fmt.Sprintf("%s %s (%s)", g.PCIAddress, name, g.Driver)
+	}
+	return fmt.Sprintf("%s %s", g.PCIAddress, name)
+}
+
+// wantsGPU reports whether an application is offered GPU passthrough by default.
+func wantsGPU(app Application) bool {
+	for _, c := range app.Categories {
+		if slices.Contains(gpuCategories, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostGPUs lists the GPUs of the server from its resources.
+func (c *cmdGlobal) hostGPUs() ([]hostGPU, error) {
+	server, err := c.server()
+	if err != nil {
+		return nil, err
+	}
+	res, err := server.GetServerResources()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server resources: %w", err)
+	}
+	var gpus []hostGPU
+	for _, card := range res.GPU.Cards {
+		if card.PCIAddress == "" {
+			continue
+		}
+		gpu := hostGPU{
+			PCIAddress: card.PCIAddress,
+			Vendor:     card.Vendor,
+			VendorID:   card.VendorID,
+			Product:    card.Product,
+			Driver:     card.Driver,
+			Nvidia:     card.Nvidia != nil,
+		}
+		for name, m := range card.Mdev {
+			if m.Available > 0 {
+				gpu.Mdev = append(gpu.Mdev, name)
+			}
+		}
+		slices.Sort(gpu.Mdev)
+		gpus = append(gpus, gpu)
+	}
+	return gpus, nil
+}
+
+// selectGPU finds a GPU by PCI address, with or without the domain, or by
+// vendor name or ID. "any" selects the first GPU.
+func selectGPU(gpus []hostGPU, value string) (hostGPU, error) {
+	if len(gpus) == 0 {
+		return hostGPU{}, errors.New("the host has no GPUs")
+	}
+	if value == anyGPU {
+		return gpus[0], nil
+	}
+	var matches []hostGPU
+	for _, g := range gpus {
+		if g.PCIAddress == value || strings.TrimPrefix(g.PCIAddress, "0000:") == value {
+			return g, nil
+		}
+		if strings.EqualFold(g.VendorID, value) || strings.Contains(strings.ToLower(g.Vendor), strings.ToLower(value)) {
+			matches = append(matches, g)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		addresses := make([]string, len(gpus))
+		for i, g := range gpus {
+			addresses[i] = g.String()
+		}
+		return hostGPU{}, fmt.Errorf("no GPU matches %q, the host has %s", value, strings.Join(addresses, ", "))
+	case 1:
+		return matches[0], nil
+	default:
+		return hostGPU{}, fmt.Errorf("%d GPUs match %q, choose one by PCI address", len(matches), value)
+	}
+}
+
+// gpuConfig is the instance configuration a GPU needs. Containers get the
+// NVIDIA runtime for NVIDIA cards, including the video capability for
+// transcoding.
+func gpuConfig(gpu hostGPU, vm bool) map[string]string {
+	if vm || !gpu.Nvidia {
+		return nil
+	}
+	return map[string]string{
+		"nvidia.runtime":             "true",
+		"nvidia.driver.capabilities": "compute,utility,video",
+	}
+}
+
+// gpuDevice passes a GPU through to an instance. Containers share the card
+// with the host, with the device nodes owned by gid. VMs take the whole card,
+// or a mediated device when mdev names a profile.
+func gpuDevice(gpu hostGPU, vm bool, mdev string, gid string) map[string]string {
+	device := map[string]string{"type": "gpu", "pci": gpu.PCIAddress}
+	switch {
+	case vm && mdev != "":
+		device["gputype"] = "mdev"
+		device["mdev"] = mdev
+	case vm:
+		device["gputype"] = "physical"
+	default:
+		device["gputype"] = "physical"
+		device["uid"] = "0"
+		if gid != "" {
+			device["gid"] = gid
+		}
+	}
+	return device
+}
+
+// groupID returns the ID of the first of the named groups in an /etc/group file.
+func groupID(group []byte, names ...string) string {
+	ids := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(group))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 3 {
+			ids[fields[0]] = fields[2]
+		}
+	}
+	for _, name := range names {
+		if id, ok := ids[name]; ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// instanceGroupID looks up the first of the named groups in the /etc/group
+// of a container. It is empty when the container has none of them.
+func instanceGroupID(server incus.InstanceServer, name string, groups ...string) (string, error) {
+	rc, _, err := server.GetInstanceFile(name, "/etc/group")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the groups of %s: %w", name, err)
+	}
+	defer rc.Close()
+	group, err := io.ReadAll(rc)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the groups of %s: %w", name, err)
+	}
+	return groupID(group, groups...), nil
+}
+
+// gpuGroupID is the group the GPU device nodes of a container belong to,
+// render or else video.
+func gpuGroupID(server incus.InstanceServer, name string) string {
+	gid, err := instanceGroupID(server, name, "render", "video")
+	if err != nil {
+		log.Warn("GPU devices are owned by root", "error", err)
+	} else if gid == "" {
+		log.Warn("The image has no render or video group, GPU devices are owned by root")
+	}
+	return gid
+}
+
+// gpuForm asks whether to pass a GPU through, which one and, for VMs, whether
+// to use a mediated device.
+func gpuForm(settings *LaunchSettings, gpus []hostGPU, enable bool, accessible bool) error {
+	if len(gpus) == 0 {
+		settings.GPU = ""
+		return nil
+	}
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("Pass through GPU?").
+				Value(&enable).
+				Affirmative("Yes").
+				Negative("No"),
+		),
+	).WithAccessible(accessible)
+	err := form.Run()
+	if err != nil {
+		return err
+	}
+	if !enable {
+		settings.GPU = ""
+		return nil
+	}
+
+	if settings.GPU == "" {
+		settings.GPU = gpus[0].PCIAddress
+	}
+	if len(gpus) > 1 {
+		options := make([]huh.Option[string], len(gpus))
+		for i, g := range gpus {
+			options[i] = huh.NewOption(g.String(), g.PCIAddress)
+		}
+		form = huh.NewForm(
+			huh.NewGroup(
+				huh.NewSelect[string]().
+					Value(&settings.GPU).
+					Title("Choose GPU").
+					Options(options...),
+			),
+		).WithAccessible(accessible)
+		err = form.Run()
+		if err != nil {
+			return err
+		}
+	}
+
+	gpu, _ := selectGPU(gpus, settings.GPU)
+	if settings.VM && len(gpu.Mdev) > 0 {
+		options := []huh.Option[string]{huh.NewOption("Whole card (physical passthrough)", "")}
+		for _, m := range gpu.Mdev {
+			options = append(options, huh.NewOption("Mediated device "+m, m))
+		}
+		form = huh.NewForm(
+			huh.NewGroup(
+				huh.NewSelect[string]().
+					Value(&settings.GPUMdev).
+					Title("Choose GPU Type").
+					Options(options...).
+					Description("A mediated device shares the card between VMs, passthrough gives it to one VM."),
+			),
+		).WithAccessible(accessible)
+		err = form.Run()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
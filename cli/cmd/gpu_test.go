@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_selectGPU(t *testing.T) {
+	gpus := []hostGPU{
+		{PCIAddress: "0000:00:02.0", Vendor: "Intel Corporation", VendorID: "8086"},
+		{PCIAddress: "0000:03:00.0", Vendor: "NVIDIA Corporation", VendorID: "10de", Nvidia: true},
+		{PCIAddress: "0000:04:00.0", Vendor: "NVIDIA Corporation", VendorID: "10de", Nvidia: true},
+	}
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"any", anyGPU, "0000:00:02.0", false},
+		{"pci address", "0000:03:00.0", "0000:03:00.0", false},
+		{"short pci address", "04:00.0", "0000:04:00.0", false},
+		{"vendor", "intel", "0000:00:02.0", false},
+		{"vendor id", "8086", "0000:00:02.0", false},
+		{"ambiguous vendor", "nvidia", "", true},
+		{"no match", "amd", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectGPU(gpus, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectGPU() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.PCIAddress != tt.want {
+				t.Errorf("selectGPU() = %v, want %v", got.PCIAddress, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gpuDevice(t *testing.T) {
+	gpu := hostGPU{PCIAddress: "0000:03:00.0"}
+	tests := []struct {
+		name string
+		vm   bool
+		mdev string
+		gid  string
+		want map[string]string
+	}{
+		{"container", false, "", "993", map[string]string{"type": "gpu", "gputype": "physical", "pci": "0000:03:00.0", "uid": "0", "gid": "993"}},
+		{"container without group", false, "", "", map[string]string{"type": "gpu", "gputype": "physical", "pci": "0000:03:00.0", "uid": "0"}},
+		{"vm", true, "", "", map[string]string{"type": "gpu", "gputype": "physical", "pci": "0000:03:00.0"}},
+		{"vm mdev", true, "i915-GVTg_V5_4", "", map[string]string{"type": "gpu", "gputype": "mdev", "mdev": "i915-GVTg_V5_4", "pci": "0000:03:00.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gpuDevice(gpu, tt.vm, tt.mdev, tt.gid); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gpuDevice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_groupID(t *testing.T) {
+	group := []byte("root:x:0:\nvideo:x:44:\nrender:x:993:\n")
+	tests := []struct {
+		name  string
+		names []string
+		want  string
+	}{
+		{"render first", []string{"render", "video"}, "993"},
+		{"video", []string{"video"}, "44"},
+		{"missing", []string{"kvm"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := groupID(group, tt.names...); got != tt.want {
+				t.Errorf("groupID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
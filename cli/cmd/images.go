@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/spf13/viper"
+)
+
+// defaultOSVersions is used when a catalog entry names an OS but no version.
+var defaultOSVersions = map[string]string{
+	"debian":    "12",
+	"ubuntu":    "24.04",
+	"alpine":    "3.21",
+	"archlinux": "current",
+}
+
+// osCodenames maps the release codenames used in the catalog to versions.
+var osCodenames = map[string]map[string]string{
+	"debian": {
+		"buster":   "10",
+		"bullseye": "11",
+		"bookworm": "12",
+		"trixie":   "13",
+	},
+	"ubuntu": {
+		"focal":    "20.04",
+		"jammy":    "22.04",
+		"mantic":   "23.10",
+		"noble":    "24.04",
+		"oracular": "24.10",
+		"plucky":   "25.04",
+	},
+}
+
+// osFallbacks names the release to try when a release is end-of-life and its
+// images are no longer published. Interim Ubuntu releases fall back to the LTS.
+var osFallbacks = map[string]map[string]string{
+	"debian": {
+		"10": "11",
+		"11": "12",
+		"12": "13",
+	},
+	"ubuntu": {
+		"20.04": "22.04",
+		"22.04": "24.04",
+		"23.04": "24.04",
+		"23.10": "24.04",
+		"24.10": "24.04",
+		"25.04": "24.04",
+	},
+}
+
+// normalizeOS returns the lower case OS and version for a catalog entry,
+// filling in defaults and replacing codenames with version numbers.
+func normalizeOS(osName string, version string) (string, string) {
+	osName = strings.ToLower(strings.TrimSpace(osName))
+	version = strings.ToLower(strings.TrimSpace(version))
+	if osName == "" {
+		osName = "debian"
+	}
+	if v, ok := osCodenames[osName][version]; ok {
+		version = v
+	}
+	if version == "" {
+		version = defaultOSVersions[osName]
+	}
+	if version == "" {
+		version = "current"
+	}
+	return osName, version
+}
+
+// osVersionCandidates lists the version to use for an OS, followed by the
+// releases to fall back to in order of preference.
+func osVersionCandidates(os string, version string) []string {
+	candidates := []string{version}
+	seen := map[string]bool{version: true}
+	for {
+		next, ok := osFallbacks[os][version]
+		if os == "alpine" {
+			next, ok = nextMinorVersion(version)
+			ok = ok && len(candidates) < 4
+		}
+		if !ok || seen[next] {
+			return candidates
+		}
+		seen[next] = true
+		candidates = append(candidates, next)
+		version = next
+	}
+}
+
+// nextMinorVersion turns "3.21" into "3.22".
+func nextMinorVersion(version string) (string, bool) {
+	major, minor, found := strings.Cut(version, ".")
+	if !found {
+		return "", false
+	}
+	n, err := strconv.Atoi(minor)
+	if err != nil {
+		return "", false
+	}
+	return major + "." + strconv.Itoa(n+1), true
+}
+
+// imageRef is an image on an image remote, as selected for a launch.
+type imageRef struct {
+	Remote string
+	// Name is an image alias or fingerprint on the remote.
+	Name string
+	// OS and Version describe the image to the install scripts.
+	OS      string
+	Version string
+	// Requested is the alias the catalog asked for, when a fallback was used.
+	Requested string
+	// Fingerprint is the image the name resolved to for the server architecture.
+	Fingerprint string
+}
+
+func (i imageRef) String() string {
+	return i.Remote + ":" + i.Name
+}
+
+// resolveImage finds the image for r on the image server, confirming it is
+// published for arch and falling back to a newer release when the catalog
+// entry points at one that is end-of-life.
+func resolveImage(server incus.ImageServer, remote string, r Resources, vm bool, arch string) (imageRef, error) {
+	imageType := string(api.InstanceTypeContainer)
+	if vm {
+		imageType = string(api.InstanceTypeVM)
+	}
+
+	osName, version := normalizeOS(r.OS, r.Version)
+	requested := osName + "/" + version
+	for _, v := range osVersionCandidates(osName, version) {
+		ref := imageRef{Remote: remote, Name: osName + "/" + v, OS: osName, Version: v}
+		archs, err := server.GetImageAliasArchitectures(imageType, ref.Name)
+		if err != nil {
+			log.Debug("Image alias not found", "alias", ref.Name, "type", imageType, "error", err)
+			continue
+		}
+		entry, ok := archs[arch]
+		if !ok {
+			log.Debug("Image alias not published for architecture", "alias", ref.Name, "architecture", arch)
+			continue
+		}
+		ref.Fingerprint = entry.Target
+		if v != version {
+			ref.Requested = requested
+			log.Warn("Catalog image is no longer published, using a newer release", "requested", requested, "image", ref.String())
+		}
+		return ref, nil
+	}
+	return imageRef{}, fmt.Errorf("the catalog entry points at image %s:%s, which no longer exists for %s %s", remote, requested, arch, imageType)
+}
+
+// checkImage confirms that an explicitly chosen image exists on its remote and
+// can be used for the instance type, returning its fingerprint. name is an
+// alias or a fingerprint. Aliases are looked up for the instance type, as
+// simplestreams remotes publish container and VM images under the same alias.
+func checkImage(server incus.ImageServer, remote string, name string, vm bool) (string, error) {
+	imageType := string(api.InstanceTypeContainer)
+	if vm {
+		imageType = string(api.InstanceTypeVM)
+	}
+
+	alias, _, err := server.GetImageAliasType(imageType, name)
+	if err == nil {
+		return alias.Target, nil
+	}
+	other, _, err := server.GetImageAlias(name)
+	if err == nil {
+		return "", fmt.Errorf("image %s:%s is a %s image, not a %s image", remote, name, other.Type, imageType)
+	}
+	if !isFingerprint(name) {
+		return "", fmt.Errorf("image %s:%s does not exist", remote, name)
+	}
+	image, _, err := server.GetImage(name)
+	if err != nil {
+		return "", fmt.Errorf("image %s:%s does not exist", remote, name)
+	}
+	if image.Type != "" && image.Type != imageType {
+		return "", fmt.Errorf("image %s:%s is a %s image, not a %s image", remote, name, image.Type, imageType)
+	}
+	return image.Fingerprint, nil
+}
+
+// isFingerprint reports whether name can be an image fingerprint, or a
+// prefix of one.
+func isFingerprint(name string) bool {
+	if len(name) < 12 || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// imageSettings returns the image and image remote configured for an
+// application. override, usually from --image, takes precedence over the
+// apps.<slug>.image configuration key.
+func imageSettings(application Application, override string) (string, string) {
+	appKey := "apps." + application.GetSlug()
+	image := override
+	if image == "" {
+		image = viper.GetString(appKey + ".image")
+	}
+	remote := viper.GetString(appKey + ".image-remote")
+	if remote == "" {
+		remote = viper.GetString("image-remote")
+	}
+	return image, remote
+}
+
+// selectImage picks the image for an instance of application. An explicitly
+// configured image is used as is, otherwise the catalog OS and version are
+// resolved on the configured image remote.
+func (c *cmdGlobal) selectImage(application Application, r Resources, vm bool, override string) (imageRef, error) {
+	image, remote := imageSettings(application, override)
+	osName, version := normalizeOS(r.OS, r.Version)
+
+	if image != "" {
+		remote, name, err := c.conf.ParseRemote(image)
+		if err != nil {
+			return imageRef{}, err
+		}
+		imageServer, err := c.conf.GetImageServer(remote)
+		if err != nil {
+			return imageRef{}, fmt.Errorf("failed to connect to image remote %q: %w", remote, err)
+		}
+		fingerprint, err := checkImage(imageServer, remote, name, vm)
+		if err != nil {
+			return imageRef{}, err
+		}
+		return imageRef{Remote: remote, Name: name, OS: osName, Version: version, Fingerprint: fingerprint}, nil
+	}
+
+	imageServer, err := c.conf.GetImageServer(remote)
+	if err != nil {
+		return imageRef{}, fmt.Errorf("failed to connect to image remote %q: %w", remote, err)
+	}
+	arch, err := c.serverArchitecture()
+	if err != nil {
+		return imageRef{}, err
+	}
+	return resolveImage(imageServer, remote, r, vm, arch)
+}
+
+// serverArchitecture returns the kernel architecture of the default remote.
+func (c *cmdGlobal) serverArchitecture() (string, error) {
+	server, err := c.server()
+	if err != nil {
+		return "", err
+	}
+	info, _, err := server.GetServer()
+	if err != nil {
+		return "", err
+	}
+	return info.Environment.KernelArchitecture, nil
+}
+
+// cacheImage makes sure an image is in the local image store of server, copying
+// it from its remote when needed, and sets its auto-update property. Images
+// requested by alias are copied by alias, so incus can follow new builds when
+// auto-update is on, and get a local alias from cacheAlias.
+func (c *cmdGlobal) cacheImage(server incus.InstanceServer, ref imageRef, vm bool, autoUpdate bool) (*api.Image, error) {
+	alias := cacheAlias(ref, vm)
+	local, etag, err := server.GetImage(ref.Fingerprint)
+	if err != nil && alias != "" {
+		// An auto-updating copy made from an older build is kept current by
+		// incus, so it serves the alias as well as the latest fingerprint.
+		entry, _, aliasErr := server.GetImageAlias(alias)
+		if aliasErr == nil {
+			cached, cachedEtag, cachedErr := server.GetImage(entry.Target)
+			if cachedErr == nil && cached.AutoUpdate {
+				local, etag, err = cached, cachedEtag, nil
+			}
+		}
+	}
+	if err == nil {
+		if local.AutoUpdate != autoUpdate {
+			put := local.Writable()
+			put.AutoUpdate = autoUpdate
+			err = server.UpdateImage(local.Fingerprint, put, etag)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update image %s: %w", ref, err)
+			}
+			local.AutoUpdate = autoUpdate
+		}
+		log.Info("Image already cached", "image", ref.String(), "fingerprint", local.Fingerprint[:12], "auto-update", autoUpdate)
+		return local, nil
+	}
+
+	imageServer, err := c.conf.GetImageServer(ref.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to image remote %q: %w", ref.Remote, err)
+	}
+	image, _, err := imageServer.GetImage(ref.Fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %s: %w", ref, err)
+	}
+
+	imageType := string(api.InstanceTypeContainer)
+	if vm {
+		imageType = string(api.InstanceTypeVM)
+	}
+	op, err := server.CopyImage(imageServer, copySource(*image, ref), &incus.ImageCopyArgs{AutoUpdate: autoUpdate, Type: imageType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy image %s: %w", ref, err)
+	}
+	_, err = op.AddHandler(operationProgress("Copying " + ref.String()))
+	if err != nil {
+		return nil, err
+	}
+	err = op.Wait()
+	if isTerminal(os.Stderr) {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy image %s: %w", ref, err)
+	}
+	log.Info("Image cached", "image", ref.String(), "fingerprint", image.Fingerprint[:12], "auto-update", autoUpdate)
+
+	local, _, err = server.GetImage(image.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if alias != "" {
+		err = setImageAlias(server, alias, local.Fingerprint, imageType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create image alias %s: %w", alias, err)
+		}
+	}
+	return local, nil
+}
+
+// cacheAliasPrefix starts the local aliases of cached remote images, apart
+// from the vmImagePrefix aliases of imported disk images.
+const cacheAliasPrefix = "scriptcli-cache/"
+
+// cacheAlias returns the local alias of an image cached from ref, or "" when
+// ref names its image by fingerprint.
+func cacheAlias(ref imageRef, vm bool) string {
+	if ref.Name == "" || isFingerprint(ref.Name) {
+		return ""
+	}
+	alias := cacheAliasPrefix + ref.Remote + "/" + ref.Name
+	if vm {
+		alias += "/vm"
+	}
+	return alias
+}
+
+// copySource returns the image to pass to CopyImage. Public images requested by
+// alias are copied by that alias, as incus records the copy source to find new
+// builds when it auto-updates the image.
+func copySource(image api.Image, ref imageRef) api.Image {
+	if image.Public && ref.Name != "" && !strings.HasPrefix(image.Fingerprint, ref.Name) {
+		image.Fingerprint = ref.Name
+	}
+	return image
+}
+
+// setImageAlias points the local alias name at fingerprint, creating it when
+// it does not exist yet.
+func setImageAlias(server incus.InstanceServer, name string, fingerprint string, imageType string) error {
+	alias, etag, err := server.GetImageAlias(name)
+	if err == nil {
+		if alias.Target == fingerprint {
+			return nil
+		}
+		put := api.ImageAliasesEntryPut{Description: alias.Description, Target: fingerprint}
+		return server.UpdateImageAlias(name, put, etag)
+	}
+	return server.CreateImageAlias(api.ImageAliasesPost{
+		ImageAliasesEntry: api.ImageAliasesEntry{
+			ImageAliasesEntryPut: api.ImageAliasesEntryPut{Target: fingerprint},
+			Name:                 name,
+			Type:                 imageType,
+		},
+	})
+}
+
+// operationProgress prints the progress an incus operation reports in its
+// metadata on a single updating line.
+func operationProgress(label string) func(api.Operation) {
+	return func(op api.Operation) {
+		if !isTerminal(os.Stderr) {
+			return
+		}
+		for k, v := range op.Metadata {
+			if strings.HasSuffix(k, "_progress") {
+				fmt.Fprintf(os.Stderr, "\r\033[K%s: %v", label, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+type cmdImages struct {
+	global *cmdGlobal
+}
+
+func (c *cmdImages) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "images"
+	cmd.Short = "manage the images used by the catalog"
+	cmd.Long =
+		`Manage the images used by the catalog`
+
+	prefetchCmd := cmdImagesPrefetch{global: c.global}
+	cmd.AddCommand(prefetchCmd.Command())
+
+	// Workaround for subcommand usage errors. See: https://github.com/spf13/cobra/issues/706
+	cmd.Args = cobra.NoArgs
+	cmd.Run = func(cmd *cobra.Command, args []string) { _ = cmd.Usage() }
+	return cmd
+}
+
+type cmdImagesPrefetch struct {
+	global *cmdGlobal
+
+	flagApps       []string
+	flagCategories []string
+	flagVM         bool
+	flagAutoUpdate bool
+	flagDryRun     bool
+}
+
+func (c *cmdImagesPrefetch) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "prefetch"
+	cmd.Short = "copy catalog images into the local image store"
+	cmd.Args = cobra.NoArgs
+	cmd.Long =
+		`Copy catalog images into the local image store
+
+Resolves the images used by the catalog, or by the applications and categories given,
+and copies them into the local image store ahead of time so launches don't have to
+download them.
+
+Categories can be given by id or name. Images are kept up to date by incus unless
+--auto-update=false is given.`
+	cmd.Example = `  scripts-cli images prefetch
+  scripts-cli images prefetch --apps immich,frigate
+  scripts-cli images prefetch --category 13 --vm`
+	cmd.Flags().StringSliceVar(&c.flagApps, "apps", nil, "only prefetch images for these applications")
+	cmd.Flags().StringSliceVar(&c.flagCategories, "category", nil, "only prefetch images for applications in these categories")
+	cmd.Flags().BoolVar(&c.flagVM, "vm", false, "prefetch virtual machine images instead of container images")
+	cmd.Flags().BoolVar(&c.flagAutoUpdate, "auto-update", true, "have incus keep the cached images up to date")
+	cmd.Flags().BoolVar(&c.flagDryRun, "dry-run", false, "only list the images that would be copied")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+// prefetchImage is one image used by one or more catalog applications.
+type prefetchImage struct {
+	ref  imageRef
+	apps []string
+}
+
+func (c *cmdImagesPrefetch) Run(cmd *cobra.Command, args []string) error {
+	apps, err := c.selectApps()
+	if err != nil {
+		return err
+	}
+	if len(apps) == 0 {
+		return fmt.Errorf("no applications match the given filters")
+	}
+
+	images, err := c.resolve(apps)
+	if err != nil {
+		return err
+	}
+
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		log.Info("Image", "image", img.ref.String(), "applications", len(img.apps))
+		if c.flagDryRun {
+			continue
+		}
+		_, err = c.global.cacheImage(server, img.ref, c.flagVM, c.flagAutoUpdate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectApps returns the catalog applications matching the filters.
+func (c *cmdImagesPrefetch) selectApps() ([]Application, error) {
+	catalog, err := getContainerCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	categories := map[int]bool{}
+	if len(c.flagCategories) > 0 {
+		metadata, err := getMetadata()
+		if err != nil {
+			return nil, err
+		}
+		for _, want := range c.flagCategories {
+			id, err := strconv.Atoi(want)
+			found := false
+			for _, cat := range metadata.Categories {
+				if (err == nil && cat.ID == id) || strings.EqualFold(cat.Name, want) {
+					categories[cat.ID] = true
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown category '%s'", want)
+			}
+		}
+	}
+
+	var apps []Application
+	for key, a := range catalog {
+		if len(c.flagApps) > 0 && !slices.Contains(c.flagApps, key) && !slices.Contains(c.flagApps, a.Slug) {
+			continue
+		}
+		if len(categories) > 0 && !slices.ContainsFunc(a.Categories, func(id int) bool { return categories[id] }) {
+			continue
+		}
+		apps = append(apps, a)
+	}
+	for _, want := range c.flagApps {
+		if _, ok := catalog[want]; !ok {
+			return nil, fmt.Errorf("app '%s' not found in catalog", want)
+		}
+	}
+	return apps, nil
+}
+
+// resolve finds the images for every install method of apps. Applications
+// asking for the same image are resolved once.
+func (c *cmdImagesPrefetch) resolve(apps []Application) ([]*prefetchImage, error) {
+	byKey := map[string]*prefetchImage{}
+	byFingerprint := map[string]*prefetchImage{}
+	var images []*prefetchImage
+	for _, a := range apps {
+		for _, m := range a.InstallMethods {
+			image, remote := imageSettings(a, "")
+			key := image + "|" + remote + "|" + m.Resources.Image()
+			img, ok := byKey[key]
+			if !ok {
+				ref, err := c.global.selectImage(a, m.Resources, c.flagVM, "")
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", a.Slug, err)
+				}
+				img, ok = byFingerprint[ref.Fingerprint]
+				if !ok {
+					img = &prefetchImage{ref: ref}
+					byFingerprint[ref.Fingerprint] = img
+					images = append(images, img)
+				}
+				byKey[key] = img
+			}
+			if !slices.Contains(img.apps, a.Slug) {
+				img.apps = append(img.apps, a.Slug)
+			}
+		}
+	}
+	return images, nil
+}
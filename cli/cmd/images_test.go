@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// fakeImageServer serves aliases by type and images by fingerprint, with
+// GetImageAlias preferring containers like simplestreams remotes do.
+type fakeImageServer struct {
+	incus.ImageServer
+	aliases map[string]map[string]string
+	images  map[string]string
+}
+
+var errFakeNotFound = errors.New("not found")
+
+func (f fakeImageServer) GetImageAliasType(imageType string, name string) (*api.ImageAliasesEntry, string, error) {
+	target, ok := f.aliases[imageType][name]
+	if !ok {
+		return nil, "", errFakeNotFound
+	}
+	return &api.ImageAliasesEntry{Name: name, Type: imageType, ImageAliasesEntryPut: api.ImageAliasesEntryPut{Target: target}}, "", nil
+}
+
+func (f fakeImageServer) GetImageAlias(name string) (*api.ImageAliasesEntry, string, error) {
+	alias, etag, err := f.GetImageAliasType("container", name)
+	if err != nil {
+		return f.GetImageAliasType("virtual-machine", name)
+	}
+	return alias, etag, err
+}
+
+func (f fakeImageServer) GetImage(fingerprint string) (*api.Image, string, error) {
+	imageType, ok := f.images[fingerprint]
+	if !ok {
+		return nil, "", errFakeNotFound
+	}
+	return &api.Image{Fingerprint: fingerprint, Type: imageType}, "", nil
+}
+
+var testImageServer = fakeImageServer{
+	aliases: map[string]map[string]string{
+		"container": {
+			"debian/12":       "c0ffee000000000000000001",
+			"debian/12/cloud": "c0ffee000000000000000002",
+			"alpine/3.21":     "c0ffee000000000000000005",
+		},
+		"virtual-machine": {
+			"debian/12":       "beef00000000000000000003",
+			"debian/12/cloud": "beef00000000000000000004",
+		},
+	},
+	images: map[string]string{
+		"c0ffee000000000000000001": "container",
+		"beef00000000000000000003": "virtual-machine",
+	},
+}
+
+func Test_checkImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		vm      bool
+		want    string
+		wantErr bool
+	}{
+		{"container alias", "debian/12", false, "c0ffee000000000000000001", false},
+		{"vm alias shared with a container", "debian/12", true, "beef00000000000000000003", false},
+		{"container only alias for a vm", "alpine/3.21", true, "", true},
+		{"fingerprint", "beef00000000000000000003", true, "beef00000000000000000003", false},
+		{"fingerprint of the wrong type", "c0ffee000000000000000001", true, "", true},
+		{"missing", "debian/13", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkImage(testImageServer, "images", tt.image, tt.vm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("checkImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cloudVariant(t *testing.T) {
+	ref := imageRef{Remote: "images", Name: "debian/12", Fingerprint: "beef00000000000000000003"}
+	got := cloudVariant(testImageServer, ref)
+	if got.Name != "debian/12/cloud" || got.Fingerprint != "beef00000000000000000004" {
+		t.Errorf("cloudVariant() = %v %v, want the VM cloud image", got.Name, got.Fingerprint)
+	}
+
+	ref = imageRef{Remote: "images", Name: "alpine/3.21"}
+	if got := cloudVariant(testImageServer, ref); got != ref {
+		t.Errorf("cloudVariant() = %v, want %v without a cloud variant", got, ref)
+	}
+}
+
+func Test_copySource(t *testing.T) {
+	const fingerprint = "c0ffee000000000000000001"
+	tests := []struct {
+		name   string
+		public bool
+		ref    string
+		want   string
+	}{
+		{"public alias", true, "debian/12", "debian/12"},
+		{"public fingerprint", true, fingerprint, fingerprint},
+		{"public fingerprint prefix", true, fingerprint[:12], fingerprint},
+		{"private alias", false, "debian/12", fingerprint},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := api.Image{Fingerprint: fingerprint}
+			image.Public = tt.public
+			got := copySource(image, imageRef{Remote: "images", Name: tt.ref})
+			if got.Fingerprint != tt.want {
+				t.Errorf("copySource() = %v, want %v", got.Fingerprint, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cacheAlias(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  imageRef
+		vm   bool
+		want string
+	}{
+		{"container", imageRef{Remote: "images", Name: "debian/12"}, false, "scriptcli-cache/images/debian/12"},
+		{"vm", imageRef{Remote: "images", Name: "debian/12/cloud"}, true, "scriptcli-cache/images/debian/12/cloud/vm"},
+		{"fingerprint", imageRef{Remote: "images", Name: "c0ffee000000000000000001"}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cacheAlias(tt.ref, tt.vm); got != tt.want {
+				t.Errorf("cacheAlias() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
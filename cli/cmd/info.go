@@ -0,0 +1,102 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+type cmdInfo struct {
+	global *cmdGlobal
+}
+
+func (c *cmdInfo) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "info <application>"
+	cmd.Short = "show application details"
+	cmd.Args = cobra.ExactArgs(1)
+	cmd.Long =
+		`Show application details
+
+Show the catalog entry for an application, including the catalog source it was loaded from.
+Prefix the application with a source name, for example "internal/grafana", to choose the source explicitly.`
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdInfo) Run(cmd *cobra.Command, args []string) error {
+	log.Debug("Loading application", "application", args[0])
+	application, err := getAppMetadata(args[0])
+	if err != nil {
+		return err
+	}
+
+	t, err := template.New("info").Parse(infoMessage)
+	if err != nil {
+		return err
+	}
+	bb := bytes.Buffer{}
+	err = t.Execute(&bb, application)
+	if err != nil {
+		return err
+	}
+	output, err := glamour.Render(bb.String(), "dark")
+	if err != nil {
+		return err
+	}
+	fmt.Print(output)
+	return nil
+}
+
+var infoMessage = `# {{.Name}}
+
+- Slug: {{.Slug}}
+- Source: {{.Source}}
+- Type: {{.Type}}
+{{if .DefaultCredentials.Username }}- Default Credentials: User:{{.DefaultCredentials.Username}} / Password: {{.DefaultCredentials.Password}}{{end}}
+
+{{.Description}}
+
+## Install Methods
+{{range .InstallMethods}}
+- {{.Type}}: {{.Resources.Image}} ({{.Resources.CPU}} CPU, {{.Resources.RAM}}MiB RAM, {{.Resources.HDD}}GiB disk)
+{{- end}}
+
+{{if .Notes}}## Notes
+{{range .Notes}}
+- {{.Text}}
+{{- end}}{{end}}
+
+## Resources
+{{if .Website}}Website: [{{.Name}}]({{.Website}}){{end}}
+
+{{if .Documentation}}Documentation: [{{.Name}}]({{.Documentation}}){{end}}
+
+{{if ne .InterfacePort 0 }}Application Port : {{.InterfacePort}}{{end}}
+`
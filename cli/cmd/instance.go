@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// provenancePrefix marks the instance configuration keys recording how an
+// instance was launched. Published images carry the same values as
+// "scriptcli.*" image properties.
+const provenancePrefix = "user.scriptcli."
+
+// provenance describes the catalog application an instance or image was built from.
+func provenance(application Application, method InstallMethods, image imageRef) map[string]string {
+	return map[string]string{
+		"app":            application.Slug,
+		"name":           application.Name,
+		"source":         application.Source,
+		"install-method": method.Type,
+		"image":          image.String(),
+		"os":             image.OS,
+		"version":        image.Version,
+		"launched":       time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// instanceProvenance reads the provenance recorded on an instance at launch.
+func instanceProvenance(config map[string]string) map[string]string {
+	p := map[string]string{}
+	for k, v := range config {
+		if name, ok := strings.CutPrefix(k, provenancePrefix); ok {
+			p[name] = v
+		}
+	}
+	return p
+}
+
+// instanceExec runs a command in a running instance and returns its exit code.
+func instanceExec(server incus.InstanceServer, name string, command []string, env map[string]string, stdout io.Writer, stderr io.Writer) (int, error) {
+	args := &incus.InstanceExecArgs{
+		Stdout:   stdout,
+		Stderr:   stderr,
+		DataDone: make(chan bool),
+	}
+	op, err := server.ExecInstance(name, api.InstanceExecPost{
+		Command:     command,
+		Environment: env,
+		WaitForWS:   true,
+	}, args)
+	if err != nil {
+		return -1, fmt.Errorf("failed to run %s in %s: %w", command[0], name, err)
+	}
+	err = op.Wait()
+	if err != nil {
+		return -1, fmt.Errorf("failed to run %s in %s: %w", command[0], name, err)
+	}
+	<-args.DataDone
+
+	ret, ok := op.Get().Metadata["return"].(float64)
+	if !ok {
+		return -1, fmt.Errorf("no exit code for %s in %s", command[0], name)
+	}
+	return int(ret), nil
+}
+
+// instanceShell runs a shell script in a running instance and fails when it
+// exits with a non-zero code.
+func instanceShell(server incus.InstanceServer, name string, script string, env map[string]string) error {
+	var stderr strings.Builder
+	ret, err := instanceExec(server, name, []string{"/bin/sh", "-c", script}, env, nil, &stderr)
+	if err != nil {
+		return err
+	}
+	if ret != 0 {
+		return fmt.Errorf("command in %s exited with code %d: %s", name, ret, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// setInstanceState starts or stops an instance and waits for it.
+func setInstanceState(server incus.InstanceServer, name string, action string) error {
+	op, err := server.UpdateInstanceState(name, api.InstanceStatePut{Action: action, Timeout: 60}, "")
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", action, name, err)
+	}
+	err = op.Wait()
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", action, name, err)
+	}
+	return nil
+}
+
+// instanceSetupScript does the per-instance part of an installation for
+// instances launched from a published image. The values come from the
+// environment, like the installer's.
+const instanceSetupScript = `set -e
+if command -v ssh-keygen >/dev/null 2>&1; then ssh-keygen -A >/dev/null; fi
+if [ -n "$HN" ]; then
+  echo "$HN" > /etc/hostname
+  hostname "$HN" 2>/dev/null || true
+  sed -i "s/^127\.0\.1\.1\s.*/127.0.1.1\t$HN/" /etc/hosts
+fi
+if [ -n "$PASSWORD" ]; then echo "root:$PASSWORD" | chpasswd; fi
+if [ -n "$SSH_AUTHORIZED_KEY" ]; then
+  mkdir -p /root/.ssh
+  echo "$SSH_AUTHORIZED_KEY" > /root/.ssh/authorized_keys
+  chmod 700 /root/.ssh
+  chmod 600 /root/.ssh/authorized_keys
+fi
+if [ "$SSH_ROOT" = "yes" ] && [ -f /etc/ssh/sshd_config ]; then
+  sed -i "s/#PermitRootLogin prohibit-password/PermitRootLogin yes/g" /etc/ssh/sshd_config
+fi
+if [ -f /etc/ssh/sshd_config ]; then
+  systemctl restart ssh 2>/dev/null || systemctl restart sshd 2>/dev/null || rc-service sshd restart 2>/dev/null || true
+fi
+`
+
+// setupInstance runs instanceSetupScript for an instance launched from a published image.
+func setupInstance(server incus.InstanceServer, settings LaunchSettings) error {
+	sshRoot := "no"
+	if settings.SSHRootPassword {
+		sshRoot = "yes"
+	}
+	return instanceShell(server, settings.Name, instanceSetupScript, map[string]string{
+		"HN":                 settings.Name,
+		"PASSWORD":           settings.RootPassword,
+		"SSH_AUTHORIZED_KEY": strings.TrimSpace(settings.SSHAuthorizedKey),
+		"SSH_ROOT":           sshRoot,
+	})
+}
+
+// regenerateHostKeys creates new SSH host keys after they were removed for publishing.
+func regenerateHostKeys(server incus.InstanceServer, name string) error {
+	return instanceShell(server, name, instanceSetupScript, nil)
+}
+
+// addInstanceDevices adds or replaces devices of an instance. Running
+// instances get hot-pluggable devices immediately.
+func addInstanceDevices(server incus.InstanceServer, name string, devices map[string]map[string]string) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	inst, etag, err := server.GetInstance(name)
+	if err != nil {
+		return fmt.Errorf("failed to get instance %s: %w", name, err)
+	}
+	put := inst.Writable()
+	if put.Devices == nil {
+		put.Devices = map[string]map[string]string{}
+	}
+	maps.Copy(put.Devices, devices)
+	op, err := server.UpdateInstance(name, put, etag)
+	if err == nil {
+		err = op.Wait()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add devices to %s: %w", name, err)
+	}
+	return nil
+}
+
+// unsetInstanceConfig removes configuration keys from an instance.
+func unsetInstanceConfig(server incus.InstanceServer, name string, keys ...string) error {
+	inst, etag, err := server.GetInstance(name)
+	if err != nil {
+		return err
+	}
+	put := inst.Writable()
+	for _, k := range keys {
+		delete(put.Config, k)
+	}
+	op, err := server.UpdateInstance(name, put, etag)
+	if err == nil {
+		err = op.Wait()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", name, err)
+	}
+	return nil
+}
@@ -23,9 +23,9 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -36,18 +36,51 @@ import (
 
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/log"
-	"github.com/lxc/incus/v6/shared/api"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/huh/spinner"
 )
 
-var rootPasswordTwice string
 var doit bool
 
 type cmdLaunch struct {
 	global *cmdGlobal
+
+	flagImage            string
+	flagFromImage        string
+	flagProvision        string
+	flagCloudInitTimeout time.Duration
+	flagWaitTimeout      time.Duration
+	flagInstallMethod    string
+	flagCPU              int
+	flagMemory           string
+	flagDisk             string
+	flagStorage          string
+	flagForce            bool
+	flagNetwork          string
+	flagNICType          string
+	flagIPv4             string
+	flagIPv6             string
+	flagVLAN             int
+	flagEnableIPv6       bool
+	flagTimezone         string
+	flagLocale           string
+	flagAptCacher        string
+	flagProxy            string
+	flagNoProxy          string
+	flagPersistProxy     bool
+	flagGPU              string
+	flagGPUMdev          string
+	flagUSB              []string
+	flagSerial           []string
+	flagMounts           []string
+	flagVolumes          []string
+	flagDataVolume       bool
+	flagHardened         bool
+	flagAllowPrivileged  bool
+	flagAppProfile       bool
 }
 
 func (c *cmdLaunch) Command() *cobra.Command {
@@ -60,17 +93,77 @@ func (c *cmdLaunch) Command() *cobra.Command {
 		`launch a container
 
 Launch a container from the catalog. The application name is the name of the application in the catalog.
+Prefix it with a catalog source name, for example "internal/grafana", to choose the source explicitly.
 The instance name is the name you want to give the container. The instance name must be unique.
 
 Choose "Yes" to use default settings, or "No" to customize the launch settings.
 
-All containers can be launched as a VM. The default is to launch as a container.`
+All containers can be launched as a VM. The default is to launch as a container.
+Applications of type "vm" are created from the vm metadata in the catalog, from a
+cloud-init image, a vendor disk image or an installer ISO.
+
+Images come from the remote in the image-remote configuration key, "images" by
+default. The apps.<slug>.image-remote and apps.<slug>.image keys choose them for one
+application. Instances get the CPU, memory and root disk size of the install method.
+Launch refuses to overcommit the host unless --force is given, and waits for the
+network and DNS of the instance before installing.
+
+The timezone, locale, apt-cacher, proxy, no-proxy, persist-proxy and hardened flags
+default to the configuration keys of the same name, and --app-profile to the
+app-profiles key.`
+	cmd.Example = `  scripts-cli launch jellyfin media --gpu --mount /srv/media:/media:ro --data-volume
+  scripts-cli launch grafana grafana --network incusbr0 --ipv4 10.10.10.50
+  scripts-cli launch adguard dns --network enp3s0 --vlan 20
+  scripts-cli launch zigbee2mqtt z2m --serial usb-ITead_Sonoff_Zigbee_3.0_USB_Dongle_Plus-if00-port0
+  scripts-cli launch n8n n8n --proxy http://proxy.example.com:3128 --no-proxy .example.com
+  scripts-cli launch grafana grafana-vm --provision cloud-init --cloud-init-timeout 45m
+  scripts-cli launch immich photos --image local:debian-12-hardened
+  scripts-cli launch immich photos2 --from-image app/immich
+  scripts-cli launch vaultwarden vault --hardened --app-profile`
+	cmd.Flags().StringVar(&c.flagImage, "image", "", "image to launch, as [<remote>:]<alias or fingerprint>, instead of the catalog image")
+	cmd.Flags().StringVar(&c.flagFromImage, "from-image", "", "image made by \"scripts-cli publish\" to launch, skipping the installer")
+	cmd.Flags().StringVar(&c.flagProvision, "provision", provisionAgent, "how to provision VMs: agent, or cloud-init to pass the installer and settings as user data")
+	cmd.Flags().DurationVar(&c.flagCloudInitTimeout, "cloud-init-timeout", 30*time.Minute, "how long to wait for cloud-init to provision a VM")
+	cmd.Flags().DurationVar(&c.flagWaitTimeout, "wait-timeout", 3*time.Minute, "how long to wait for the instance agent, network and DNS before installing")
+	cmd.Flags().StringVar(&c.flagInstallMethod, "install-method", "", "install method to use, by type (default, alpine) or index")
+	cmd.Flags().IntVar(&c.flagCPU, "cpu", 0, "CPU cores, instead of the catalog default")
+	cmd.Flags().StringVar(&c.flagMemory, "memory", "", "memory limit like 2GiB, instead of the catalog default")
+	cmd.Flags().StringVar(&c.flagDisk, "disk", "", "root disk size like 8GiB, instead of the catalog default, skipped on container pools without quotas")
+	cmd.Flags().StringVar(&c.flagStorage, "storage", "", "storage pool for the root disk, instead of the pool in the profiles")
+	cmd.Flags().BoolVar(&c.flagForce, "force", false, "launch even when the host does not have enough CPU, memory or disk space")
+	cmd.Flags().StringVar(&c.flagNetwork, "network", "", "managed network, or host interface for a macvlan NIC, to attach the instance to")
+	cmd.Flags().StringVar(&c.flagNICType, "nic-type", "", "NIC type for host interfaces: macvlan or physical")
+	cmd.Flags().StringVar(&c.flagIPv4, "ipv4", "", "static IPv4 address on a managed bridge or OVN network")
+	cmd.Flags().StringVar(&c.flagIPv6, "ipv6", "", "static IPv6 address on a managed bridge or OVN network")
+	cmd.Flags().IntVar(&c.flagVLAN, "vlan", 0, "VLAN tag of the network interface")
+	cmd.Flags().BoolVar(&c.flagEnableIPv6, "enable-ipv6", false, "keep IPv6 enabled in the instance")
+	cmd.Flags().StringVar(&c.flagTimezone, "timezone", "", "timezone of the instance, like Europe/Berlin (default the host timezone)")
+	cmd.Flags().StringVar(&c.flagLocale, "locale", "", "locale of the instance, like en_US.UTF-8")
+	cmd.Flags().StringVar(&c.flagAptCacher, "apt-cacher", "", "address of an apt-cacher-ng proxy on port 3142 for Debian and Ubuntu package installs")
+	cmd.Flags().StringVar(&c.flagProxy, "proxy", "", "HTTP proxy URL for the install, like http://proxy.example.com:3128")
+	cmd.Flags().StringVar(&c.flagNoProxy, "no-proxy", "", "comma separated hosts and domains to reach without the proxy (default "+defaultNoProxy+")")
+	cmd.Flags().BoolVar(&c.flagPersistProxy, "persist-proxy", false, "keep the proxy settings in the instance after the install")
+	cmd.Flags().StringVar(&c.flagGPU, "gpu", "", "pass through a host GPU, by PCI address or vendor, or the first one without a value")
+	cmd.Flags().Lookup("gpu").NoOptDefVal = anyGPU
+	cmd.Flags().StringVar(&c.flagGPUMdev, "gpu-mdev", "", "mediated device profile of the GPU, for VMs")
+	cmd.Flags().StringSliceVar(&c.flagUSB, "usb", nil, "USB devices to pass through, by vendor:product ID or serial number")
+	cmd.Flags().StringSliceVar(&c.flagSerial, "serial", nil, "serial devices to pass through, by name in /dev/serial/by-id, on a local incus server")
+	cmd.Flags().StringArrayVar(&c.flagMounts, "mount", nil, "host path to mount, as <host path>:<instance path>[:ro]")
+	cmd.Flags().StringArrayVar(&c.flagVolumes, "volume", nil, "custom storage volume to attach, as <pool>/<volume>:<instance path>, created when missing")
+	cmd.Flags().BoolVar(&c.flagDataVolume, "data-volume", false, "keep the application data directory on its own <instance name>-data volume")
+	cmd.Flags().BoolVar(&c.flagHardened, "hardened", false, "apply only the security features the application declares, without nesting and the legacy syscall intercepts")
+	cmd.Flags().BoolVar(&c.flagAllowPrivileged, "allow-privileged", false, "create privileged containers without asking")
+	cmd.Flags().BoolVar(&c.flagAppProfile, "app-profile", false, "keep the catalog settings and devices in the scriptcli-app-<slug> profile shared by the instances of the application, see \"scripts-cli profile sync\"")
+	cmd.MarkFlagsMutuallyExclusive("image", "from-image")
 	cmd.RunE = c.Run
 
 	return cmd
 }
 
 func (c *cmdLaunch) Run(cmd *cobra.Command, args []string) error {
+	if c.flagProvision != provisionAgent && c.flagProvision != provisionCloudInit {
+		return fmt.Errorf("invalid --provision %q, use %s or %s", c.flagProvision, provisionAgent, provisionCloudInit)
+	}
 	app := args[0]
 	instanceName := args[1]
 	log.Debug("Preparing to launch", "application", app, "instance name", instanceName)
@@ -89,7 +182,87 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 	var advanced bool
 
 	launchSettings := NewLaunchSettings(*application, instanceName)
+	if c.flagInstallMethod != "" {
+		launchSettings.InstallMethod, err = selectInstallMethod(application.InstallMethods, c.flagInstallMethod)
+		if err != nil {
+			return err
+		}
+		launchSettings.ApplyResources(application.InstallMethods[launchSettings.InstallMethod].Resources)
+	}
+	err = c.applyResourceFlags(&launchSettings)
+	if err != nil {
+		return err
+	}
+	if c.flagStorage != "" {
+		err = c.global.checkStoragePool(c.flagStorage)
+		if err != nil {
+			return err
+		}
+		launchSettings.StoragePool = c.flagStorage
+	}
+	launchSettings.Network = c.flagNetwork
+	launchSettings.NICType = c.flagNICType
+	launchSettings.IPv4Address = c.flagIPv4
+	launchSettings.IPv6Address = c.flagIPv6
+	launchSettings.VLAN = c.flagVLAN
+	launchSettings.IPv6 = c.flagEnableIPv6
+	err = c.applyEnvironmentFlags(&launchSettings)
+	if err != nil {
+		return err
+	}
+	err = c.applyProxyFlags(&launchSettings)
+	if err != nil {
+		return err
+	}
+	if c.flagGPU != "" {
+		gpus, err := c.global.hostGPUs()
+		if err != nil {
+			return err
+		}
+		gpu, err := selectGPU(gpus, c.flagGPU)
+		if err != nil {
+			return err
+		}
+		launchSettings.GPU = gpu.PCIAddress
+		launchSettings.GPUMdev = c.flagGPUMdev
+	}
+	if len(c.flagUSB) > 0 || len(c.flagSerial) > 0 {
+		if len(c.flagSerial) > 0 && !c.global.localServer() {
+			return errRemoteSerial
+		}
+		devices, err := c.global.hostDevices()
+		if err != nil {
+			return err
+		}
+		launchSettings.Devices, err = selectHostDevices(devices, c.flagUSB, c.flagSerial)
+		if err != nil {
+			return err
+		}
+	}
+	for _, v := range c.flagMounts {
+		m, err := parseMount(v)
+		if err != nil {
+			return err
+		}
+		launchSettings.Mounts = append(launchSettings.Mounts, m)
+	}
+	for _, v := range c.flagVolumes {
+		m, err := parseVolume(v)
+		if err != nil {
+			return err
+		}
+		launchSettings.Volumes = append(launchSettings.Volumes, m)
+	}
+	if c.flagDataVolume {
+		if application.DataDir == "" {
+			return fmt.Errorf("%s has no data directory in the catalog, use --volume instead", application.Name)
+		}
+		launchSettings.DataVolume = true
+	}
 
+	if application.Type == "vm" {
+		return c.launchVM(*application, launchSettings, accessible)
+	}
 	if application.Type != "ct" {
 
 		log.Error("Application type not supported", "type", application.Type)
@@ -99,10 +272,7 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 
 	var isTrueNAS bool
 
-	var enableSSH bool
-	var addGPU bool
 	var profiles []string
-	var validBridges []string
 
 	isTrueNAS, err = c.global.client.IsTrueNAS(c.Command().Context())
 	if err != nil {
@@ -110,90 +280,14 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 		return err
 	}
 	if isTrueNAS {
-		profiles, err := c.global.client.ProfileNames(c.Command().Context())
+		// the default profile of TrueNAS has no root disk
+		name, err := c.global.ensureStorageProfile("")
 		if err != nil {
-			log.Error("Error getting profiles:", "error", err)
+			log.Error("Error preparing the storage profile:", "error", err)
 			return err
 		}
-		found := false
-		for _, p := range profiles {
-			if p == "scriptcli-storage" {
-				found = true
-				log.Debug("Found TrueNAS profile", "profile", p)
-				launchSettings.Profiles = append(launchSettings.Profiles, p)
-			}
-		}
-
-		// get the list of pools
-		pools, err := c.global.client.StorageList(c.Command().Context())
-		if err != nil {
-			log.Error("Error getting incus storage pools:", "error", err)
-			return err
-		}
-		if len(pools) == 0 {
-			log.Error("No storage pools found")
-			return errors.New("no storage pools found")
-		}
-		// get the default pool
-		defaultPool := ""
-		for _, pool := range pools {
-			if pool.Name == "default" {
-				defaultPool = pool.Name
-				break
-			}
-		}
-		if defaultPool == "" {
-			defaultPool = pools[0].Name
-		}
-		if found {
-			scliProfile, err := c.global.client.Profile(c.Command().Context(), "scriptcli-storage")
-			if err != nil {
-				log.Error("Error getting profile:", "error", err)
-				return err
-			}
-			rdev, ok := scliProfile.Devices["root"]
-			if ok {
-				if rdev["pool"] != defaultPool {
-					log.Error("wrong pool in profile", "incorrect pool", rdev["pool"], "correct pool", defaultPool)
-					log.Warn("Removing incorrect profile")
-					err = exec.Command("incus", "profile", "delete", "scriptcli-storage").Run()
-					if err != nil {
-						fmt.Println("Error deleting invalid storage profile:", err)
-						os.Exit(0)
-					}
-					found = false
-				}
-			}
-		}
-		if !found {
-			log.Info("No TrueNAS profiles found")
-
-			log.Debug("Using storage pool", "pool", defaultPool)
-			// create the profile
-			p := api.ProfilesPost{
-				Name: "scriptcli-storage",
-				ProfilePut: api.ProfilePut{
-					Config:      map[string]string{},
-					Description: "TrueNAS storage profile for script-cli",
-					Devices: map[string]map[string]string{
-						"root": {
-							"path": "/",
-							"pool": defaultPool,
-							"type": "disk",
-						},
-					},
-				},
-			}
-			err = c.global.client.ProfileCreate(c.Command().Context(), p)
-			if err != nil {
-				log.Error("Error getting profiles:", "error", err)
-				return err
-			}
-			log.Info("Created Incus profile", "profile", p.Name)
-			if !slices.Contains(launchSettings.Profiles, "default") {
-				launchSettings.Profiles = append(launchSettings.Profiles, "scriptcli-storage")
-			}
-
+		if !slices.Contains(launchSettings.Profiles, name) {
+			launchSettings.Profiles = append(launchSettings.Profiles, name)
 		}
 	}
 
@@ -205,17 +299,6 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 		log.Error("Instance creation cancelled")
 		return nil
 	}
-	networks, err := c.global.client.Networks(context.Background())
-	if err != nil {
-		return err
-	}
-	for _, net := range networks {
-		if net.Type == "bridge" {
-			validBridges = append(validBridges, net.Name)
-		}
-
-	}
-
 	// if it isn't a vm specific application, ask if they want to use the advanced form
 	if !launchSettings.VM {
 		advanced, err = advancedForm(accessible)
@@ -230,23 +313,21 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 			}
 		}
 	}
-	launchSettings.Image = "images:" + application.InstallMethods[0].Resources.Image()
-	log.Info("Selected image", "image", launchSettings.Image)
 	if advanced {
 
 		// select install method
-		installMethod := 0
-		if len(application.InstallMethods) > 1 {
-			// select install method
+		installMethod := launchSettings.InstallMethod
+		if len(application.InstallMethods) > 1 && c.flagInstallMethod == "" {
+			options := make([]huh.Option[int], len(application.InstallMethods))
+			for i, m := range application.InstallMethods {
+				options[i] = huh.NewOption(m.Label(), i)
+			}
 			form := huh.NewForm(
 
 				huh.NewGroup(
 					huh.NewSelect[int]().
-						Title("Choose OS Option").
-						Options(
-							huh.NewOption(application.InstallMethods[0].Resources.GetOS(), 0),
-							huh.NewOption(application.InstallMethods[1].Resources.GetOS(), 1),
-						).
+						Title("Choose Install Method").
+						Options(options...).
 						Value(&installMethod),
 				),
 			).WithAccessible(accessible)
@@ -259,55 +340,42 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 
 		}
 
-		launchSettings.Image = "images:" + application.InstallMethods[installMethod].Resources.Image()
-		launchSettings.InstallMethod = installMethod
-
-		if launchSettings.VM {
-			// VM Root Disk Size
-			// incus launch images:ubuntu/22.04 ubuntu-vm-big --vm --device root,size=30GiB
-			defaultDiskSize := fmt.Sprintf("%dGiB", application.InstallMethods[installMethod].Resources.HDD)
-			defaultMemory := fmt.Sprintf("%dMiB", application.InstallMethods[installMethod].Resources.RAM)
-			launchSettings.VMRootDiskSize = defaultDiskSize
-			launchSettings.CPU = application.InstallMethods[installMethod].Resources.CPU
-			launchSettings.RAM = defaultMemory
-			form := huh.NewForm(
-				huh.NewGroup(
-					huh.NewInput().
-						Value(&launchSettings.VMRootDiskSize).
-						Title("Root Disk Size").
-						Description("Size of the root disk for the VM.").
-						Validate(validateDiskSize),
-
-					huh.NewSelect[int]().
-						Value(&launchSettings.CPU).
-						Title("Number of CPU Cores").
-						Options(huh.NewOptions(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20)...).
-						Description("Number of CPU cores to assign the vm."),
-
-					huh.NewInput().
-						Value(&launchSettings.RAM).
-						Title("VM Memory").
-						Placeholder(defaultMemory).
-						Description("Memory amount to assign the VM.").
-						Validate(validateDiskSize),
-				),
-			).WithAccessible(accessible)
-
-			err = form.Run()
+		if installMethod != launchSettings.InstallMethod {
+			launchSettings.InstallMethod = installMethod
+			launchSettings.ApplyResources(application.InstallMethods[installMethod].Resources)
+			err = c.applyResourceFlags(&launchSettings)
 			if err != nil {
-				fmt.Println("form error:", err)
-				os.Exit(1)
+				return err
 			}
 		}
 
-		// choose ssh options
+		// Root Disk Size
+		// incus launch images:ubuntu/22.04 ubuntu-vm-big --vm --device root,size=30GiB
+		defaultMemory := launchSettings.RAM
+		kind := "container"
+		if launchSettings.VM {
+			kind = "VM"
+		}
 		form := huh.NewForm(
 			huh.NewGroup(
-				huh.NewConfirm().
-					Title("Pass through GPU?").
-					Value(&addGPU).
-					Affirmative("Yes").
-					Negative("No"),
+				huh.NewInput().
+					Value(&launchSettings.RootDiskSize).
+					Title("Root Disk Size").
+					Description("Size of the root disk for the "+kind+". Leave empty for no limit.").
+					Validate(validateOptionalSize),
+
+				huh.NewSelect[int]().
+					Value(&launchSettings.CPU).
+					Title("Number of CPU Cores").
+					Options(huh.NewOptions(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20)...).
+					Description("Number of CPU cores to assign the "+kind+"."),
+
+				huh.NewInput().
+					Value(&launchSettings.RAM).
+					Title("Memory").
+					Placeholder(defaultMemory).
+					Description("Memory amount to assign the "+kind+". Leave empty for no limit.").
+					Validate(validateOptionalSize),
 			),
 		).WithAccessible(accessible)
 
@@ -317,12 +385,24 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 			os.Exit(1)
 		}
 
-		// choose ssh options
+		// choose a GPU
+		gpus, err := c.global.hostGPUs()
+		if err != nil {
+			return err
+		}
+		err = gpuForm(&launchSettings, gpus, launchSettings.GPU != "" || wantsGPU(*application), accessible)
+		if err != nil {
+			fmt.Println("form error:", err)
+			os.Exit(1)
+		}
+
+		// choose USB and serial devices
+		addDevices := len(launchSettings.Devices) > 0 || wantsDevices(*application)
 		form = huh.NewForm(
 			huh.NewGroup(
 				huh.NewConfirm().
-					Title("Enable SSH?").
-					Value(&enableSSH).
+					Title("Pass through USB or serial devices?").
+					Value(&addDevices).
 					Affirmative("Yes").
 					Negative("No"),
 			),
@@ -333,80 +413,63 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 			fmt.Println("form error:", err)
 			os.Exit(1)
 		}
-		launchSettings.EnableSSH = enableSSH
-
-		if enableSSH {
-			home, err := os.UserHomeDir()
+		if addDevices {
+			devices, err := c.global.hostDevices()
 			if err != nil {
 				return err
 			}
-			authKeyFile := ""
-			form := huh.NewForm(
-				huh.NewGroup(
-					huh.NewConfirm().
-						Title("Allow Root SSH with Password?").
-						Value(&launchSettings.SSHRootPassword).
-						Affirmative("Yes").
-						Negative("No"),
-				),
-				huh.NewGroup(
-					huh.NewInput().
-						Value(&launchSettings.RootPassword).
-						Title("Enter Root Password").
-						Placeholder("correct-horse-battery-staple").
-						EchoMode(huh.EchoModePassword).
-						Description("Root password for the container."),
-					huh.NewInput().
-						Value(&rootPasswordTwice).
-						Title("Confirm Root Password").
-						Placeholder("correct-horse-battery-staple").
-						EchoMode(huh.EchoModePassword).
-						Description("Root password for the container.").
-						Validate(func(s string) error {
-							if s != launchSettings.RootPassword {
-								return errors.New("passwords do not match")
-							}
-							return nil
-						}),
-				),
-				huh.NewGroup(
-					huh.NewFilePicker().
-						Value(&authKeyFile).
-						Title("SSH Authorized Key").
-						FileAllowed(true).
-						DirAllowed(false).
-						AllowedTypes([]string{".pub"}).
-						ShowHidden(true).
-						ShowSize(false).
-						ShowPermissions(false).
-						CurrentDirectory(filepath.Join(home, ".ssh")).
-						Description("Press enter to choose a public key file."),
-				),
-			).WithAccessible(accessible)
-
-			err = form.Run()
+			launchSettings.Devices, err = devicesForm(devices, launchSettings.Devices, accessible)
 			if err != nil {
 				fmt.Println("form error:", err)
 				os.Exit(1)
 			}
-			bb, err := os.ReadFile(authKeyFile)
-			if err != nil {
-				fmt.Println("error reading pub key:", err)
+		} else {
+			launchSettings.Devices = nil
+		}
 
-				return err
+		// choose mounts and storage volumes
+		addMounts := len(launchSettings.Mounts) > 0 || len(launchSettings.Volumes) > 0 || launchSettings.DataVolume
+		form = huh.NewForm(
+			huh.NewGroup(
+				huh.NewConfirm().
+					Title("Mount host paths or storage volumes?").
+					Value(&addMounts).
+					Affirmative("Yes").
+					Negative("No"),
+			),
+		).WithAccessible(accessible)
+
+		err = form.Run()
+		if err != nil {
+			fmt.Println("form error:", err)
+			os.Exit(1)
+		}
+		if addMounts {
+			err = mountsForm(&launchSettings, application.DataDir, accessible)
+			if err != nil {
+				fmt.Println("form error:", err)
+				os.Exit(1)
 			}
-			launchSettings.SSHAuthorizedKey = string(bb)
+		} else {
+			launchSettings.Mounts, launchSettings.Volumes, launchSettings.DataVolume = nil, nil, false
 		}
 
-		var chooseBridge bool
+		// choose ssh options
+		err = sshForm(&launchSettings, accessible)
+		if err != nil {
+			return err
+		}
+
+		var chooseNetwork bool
 		// choose advanced network options
 		form = huh.NewForm(
 			huh.NewGroup(
 				huh.NewConfirm().
-					Title("Choose a bridge?").
-					Value(&chooseBridge).
+					Title("Customize networking?").
+					Value(&chooseNetwork).
 					Affirmative("Yes").
-					Negative("No"),
+					Negative("No").
+					Description("Choose the network, static addresses, VLAN and IPv6."),
 			),
 		).WithAccessible(accessible)
 
@@ -416,17 +479,12 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 			os.Exit(1)
 		}
 
-		if chooseBridge {
-			form = huh.NewForm(
-				huh.NewGroup(
-					huh.NewSelect[string]().
-						Value(&launchSettings.Network).
-						Title("Choose Network Bridge").
-						Options(huh.NewOptions[string](validBridges...)...).
-						Description("Select an existing network bridge for the instance."),
-				),
-			).WithAccessible(accessible)
-			err = form.Run()
+		if chooseNetwork {
+			networks, err := c.global.client.Networks(context.Background())
+			if err != nil {
+				return err
+			}
+			err = networkForm(&launchSettings, networks, accessible)
 			if err != nil {
 				fmt.Println("form error:", err)
 				os.Exit(1)
@@ -484,7 +542,109 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 		}
 		launchSettings.Profiles = profiles
 
+		// choose the storage pool for the root disk
+		if c.flagStorage == "" {
+			pools, err := c.global.storagePools()
+			if err != nil {
+				return err
+			}
+			if len(pools) > 1 {
+				options := make([]huh.Option[string], len(pools))
+				for i, p := range pools {
+					options[i] = huh.NewOption(p.String(), p.Name)
+				}
+				launchSettings.StoragePool, _ = c.global.instancePool(launchSettings)
+				form = huh.NewForm(
+					huh.NewGroup(
+						huh.NewSelect[string]().
+							Value(&launchSettings.StoragePool).
+							Title("Choose Storage Pool").
+							Options(options...).
+							Description("Storage pool for the root disk of the instance."),
+					),
+				).WithAccessible(accessible)
+				err = form.Run()
+				if err != nil {
+					fmt.Println("form error:", err)
+					os.Exit(1)
+				}
+			}
+		}
+	}
+
+	// confirm the image exists before asking to create the instance
+	override := c.flagImage
+	if c.flagFromImage != "" {
+		override = c.flagFromImage
+	}
+	image, err := c.global.selectImage(*application, application.InstallMethods[launchSettings.InstallMethod].Resources, launchSettings.VM, override)
+	if err != nil {
+		return err
+	}
+	if c.flagFromImage != "" {
+		err = c.global.checkPublishedImage(image, application.Slug)
+		if err != nil {
+			return err
+		}
+	}
+	if c.useCloudInit(launchSettings) {
+		image = c.global.cloudVariant(image)
+	}
+	launchSettings.Image = image.String()
+	log.Info("Selected image", "image", launchSettings.Image)
+
+	err = c.global.preflight(launchSettings, c.flagForce)
+	if err != nil {
+		return err
+	}
+	nicName, nic, err := c.global.networkDevice(launchSettings)
+	if err != nil {
+		return err
+	}
+	var gpu hostGPU
+	if launchSettings.GPU != "" {
+		gpus, err := c.global.hostGPUs()
+		if err != nil {
+			return err
+		}
+		gpu, err = selectGPU(gpus, launchSettings.GPU)
+		if err != nil {
+			return err
+		}
+		if launchSettings.GPUMdev != "" && (!launchSettings.VM || !slices.Contains(gpu.Mdev, launchSettings.GPUMdev)) {
+			return fmt.Errorf("mediated device %q is not available for VMs on GPU %s", launchSettings.GPUMdev, gpu.PCIAddress)
+		}
+	}
+	for _, d := range launchSettings.Devices {
+		if launchSettings.VM && d.Kind == deviceSerial {
+			return fmt.Errorf("%s cannot be attached to a VM, pass through its USB device instead", d.Path)
+		}
 	}
+	if application.Privileged && !launchSettings.VM {
+		launchSettings.Privileged = true
+		if !c.flagAllowPrivileged {
+			ok, err := privilegedForm(*application, accessible)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				log.Error("Instance creation cancelled")
+				return nil
+			}
+		}
+	}
+	shift, err := c.global.canShift(launchSettings)
+	if err != nil {
+		return err
+	}
+	dataPool := ""
+	if launchSettings.DataVolume {
+		dataPool, err = c.global.instancePool(launchSettings)
+		if err != nil {
+			return err
+		}
+	}
+
 	form := huh.NewForm(
 		huh.NewGroup(
 			huh.NewConfirm().
@@ -502,10 +662,9 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 	}
 	extraConfigs := make(map[string]string)
 	deviceOverrides := make(map[string]map[string]string)
-	// enable nesting
-	extraConfigs["security.nesting"] = "true"
-	extraConfigs["security.syscalls.intercept.mknod"] = "true"
-	extraConfigs["security.syscalls.intercept.setxattr"] = "true"
+	// security features
+	hardened := c.flagHardened || viper.GetBool("hardened")
+	maps.Copy(extraConfigs, securityConfig(*application, launchSettings.VM, hardened))
 
 	// set environment variables
 	// SSH Enable
@@ -540,44 +699,104 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 	extraConfigs["environment.APPLICATION"] = application.Name
 
 	// OS Type
-	extraConfigs["environment.PCT_OSTYPE"] = application.InstallMethods[launchSettings.InstallMethod].Resources.GetOS()
+	extraConfigs["environment.PCT_OSTYPE"] = image.OS
 
 	// OS Version
-	extraConfigs["environment.PCT_OSVERSION"] = application.InstallMethods[launchSettings.InstallMethod].Resources.GetVersion()
+	extraConfigs["environment.PCT_OSVERSION"] = image.Version
 
 	// tz
-	extraConfigs["environment.tz"] = "Etc/UTC"
+	extraConfigs["environment.tz"] = launchSettings.Timezone
+	if launchSettings.Locale != "" {
+		extraConfigs["environment.LANG"] = launchSettings.Locale
+	}
 
 	// Cacher
 	extraConfigs["environment.CACHER"] = "no"
+	if launchSettings.AptCacher != "" {
+		extraConfigs["environment.CACHER"] = "yes"
+		extraConfigs["environment.CACHER_IP"] = launchSettings.AptCacher
+	}
+
+	// Proxy
+	for k, v := range proxyEnvironment(launchSettings.Proxy, launchSettings.NoProxy) {
+		extraConfigs["environment."+k] = v
+	}
 	extraConfigs["environment.DEBIAN_FRONTEND"] = "noninteractive"
 
 	// Disable ipv6
-	extraConfigs["environment.DISABLEIPV6"] = "yes" // todo: make this a form option
+	extraConfigs["environment.DISABLEIPV6"] = "yes"
+	if ipv6Enabled(launchSettings) {
+		extraConfigs["environment.DISABLEIPV6"] = "no"
+	}
+
+	// record where the instance came from, for publish
+	for k, v := range provenance(*application, application.InstallMethods[launchSettings.InstallMethod], image) {
+		extraConfigs[provenancePrefix+k] = v
+	}
+	if c.flagFromImage != "" {
+		// the installer does not run, setupInstance gets its settings directly
+		maps.DeleteFunc(extraConfigs, func(k, v string) bool { return strings.HasPrefix(k, "environment.") })
+	}
 
 	if disableSecureBoot(application.InstallMethods[launchSettings.InstallMethod].Resources.GetOS()) {
 		launchSettings.VMSecureBoot = false
 	}
 
-	if launchSettings.VM {
-		deviceOverrides["root"] = map[string]string{"size": launchSettings.VMRootDiskSize}
-
+	if launchSettings.CPU > 0 {
 		extraConfigs["limits.cpu"] = strconv.Itoa(launchSettings.CPU)
+	}
+	if launchSettings.RAM != "" {
 		extraConfigs["limits.memory"] = launchSettings.RAM
+	}
+	if launchSettings.GPU != "" {
+		maps.Copy(extraConfigs, gpuConfig(gpu, launchSettings.VM))
+	}
+	useAppProfile := (c.flagAppProfile || viper.GetBool("app-profiles")) && !launchSettings.VM
+	if useAppProfile {
+		// the profile carries the catalog settings, the instance only what
+		// differs, ensureAppProfile refuses a profile set up differently
+		profileConfig := appProfileConfig(*application, application.InstallMethods[launchSettings.InstallMethod], hardened)
+		maps.DeleteFunc(extraConfigs, func(k, v string) bool {
+			return managedProfileKey(k) && !strings.HasPrefix(k, provenancePrefix) && profileConfig[k] == v
+		})
+	}
+	size := launchSettings.RootDiskSize
+	if size != "" && !launchSettings.VM {
+		pool, err := c.global.instancePool(launchSettings)
+		if err != nil {
+			return err
+		}
+		quota, err := c.global.supportsQuota(pool)
+		if err != nil {
+			return err
+		}
+		if !quota {
+			size = ""
+		}
+	}
+	if launchSettings.StoragePool != "" {
+		deviceOverrides["root"] = rootDevice(launchSettings.StoragePool, size)
+	} else if size != "" {
+		deviceOverrides["root"] = map[string]string{"size": size}
+	}
+	if launchSettings.VM {
 		if !launchSettings.VMSecureBoot {
 			extraConfigs["security.secureboot"] = "false"
 		}
 	}
 
+	source, err := findCatalogSource(application.Source)
+	if err != nil {
+		return err
+	}
+
 	var funcScript []byte
-	if application.InstallMethods[launchSettings.InstallMethod].Resources.GetOS() == "alpine" {
-		funcScript, err = downloadRaw(repository, "misc", "alpine-install.func")
-		if err != nil {
-			fmt.Println("download error:", err)
-			os.Exit(1)
+	if c.flagFromImage == "" {
+		funcFile := "install.func"
+		if application.InstallMethods[launchSettings.InstallMethod].Resources.GetOS() == "alpine" {
+			funcFile = "alpine-install.func"
 		}
-	} else {
-		funcScript, err = downloadRaw(repository, "misc", "install.func")
+		funcScript, err = source.download("misc", funcFile)
 		if err != nil {
 			fmt.Println("download error:", err)
 			os.Exit(1)
@@ -586,16 +805,59 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 	// Function script
 	//extraConfigs["environment.FUNCTIONS_FILE_PATH"] = string(funcScript)
 
-	extraConfigs["environment.FUNCTIONS_FILE_PATH"] = "/install.func"
+	if c.flagFromImage == "" {
+		extraConfigs["environment.FUNCTIONS_FILE_PATH"] = "/install.func"
+	}
+	if c.useCloudInit(launchSettings) {
+		installScript, err := source.download(application.Installer())
+		if err != nil {
+			return fmt.Errorf("failed to download install script: %w", err)
+		}
+		userData, err := installerCloudInit(launchSettings, extraConfigs, funcScript, installScript).userData()
+		if err != nil {
+			return err
+		}
+		extraConfigs["cloud-init.user-data"] = userData
+	}
 	log.Info("Preparing image", "image", launchSettings.Image)
 
 	createInstance := func() {
+		server, err := c.global.server()
+		if err != nil {
+			fmt.Println("Error connecting to incus:", err)
+			os.Exit(1)
+		}
+		appProfile := ""
+		if useAppProfile {
+			appProfile, err = c.global.ensureAppProfile(*application, application.InstallMethods[launchSettings.InstallMethod], hardened)
+			if err != nil {
+				fmt.Println("Error creating application profile:", err)
+				os.Exit(1)
+			}
+			if len(launchSettings.Profiles) == 0 {
+				launchSettings.Profiles = []string{"default"}
+			}
+			launchSettings.Profiles = append(launchSettings.Profiles, appProfile)
+		}
+		err = ensureVolumes(server, launchSettings, dataPool)
+		if err != nil {
+			fmt.Println("Error creating storage volumes:", err)
+			os.Exit(1)
+		}
 		// create the instance
-		err := c.global.client.Launch(launchSettings.Image, launchSettings.Name, launchSettings.Profiles, extraConfigs, deviceOverrides, launchSettings.Network, launchSettings.VM, false)
+		// the chosen network interface replaces the one of the profiles
+		err = c.global.client.Launch(launchSettings.Image, launchSettings.Name, launchSettings.Profiles, extraConfigs, deviceOverrides, "", launchSettings.VM, false)
 		if err != nil {
 			fmt.Println("Error creating instance:", err)
 			os.Exit(1)
 		}
+		if nic != nil {
+			err = c.global.client.AddDeviceToInstance(context.Background(), launchSettings.Name, nicName, nic)
+			if err != nil {
+				fmt.Println("Error adding network interface to instance:", err)
+				os.Exit(1)
+			}
+		}
 		// TODO add bash to alpine before continuing
 		//   if [ "$var_os" == "alpine" ]; then
 		//     sleep 3
@@ -605,56 +867,117 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 		// EOF'
 		//     incus exec "$HN"  -- ash -c "apk add bash >/dev/null"
 		//   fi
-		if addGPU {
-			err = c.global.client.AddDeviceToInstance(context.Background(), launchSettings.Name, "gpu", map[string]string{"type": "gpu", "gid": "44", "uid": "0"})
+		devices, err := passthroughDevices(server, launchSettings.Name, launchSettings.VM, launchSettings.Devices)
+		if err != nil {
+			fmt.Println("Error adding devices to instance:", err)
+			os.Exit(1)
+		}
+		if launchSettings.GPU != "" {
+			gid := ""
+			if !launchSettings.VM {
+				gid = gpuGroupID(server, launchSettings.Name)
+			}
+			devices["gpu"] = gpuDevice(gpu, launchSettings.VM, launchSettings.GPUMdev, gid)
+		}
+		if useAppProfile && devices["gpu"] != nil && wantsGPU(*application) {
+			// containers share the card, so GPU applications get it app-wide
+			shared, err := shareProfileDevice(server, appProfile, "gpu", devices["gpu"], gpuConfig(gpu, false))
 			if err != nil {
-				fmt.Println("Error adding GPU to instance:", err)
+				fmt.Println("Error adding the GPU to the application profile:", err)
 				os.Exit(1)
 			}
+			if shared {
+				delete(devices, "gpu")
+			}
+		}
+		if !useAppProfile {
+			maps.Copy(devices, securityDevices(*application, launchSettings.VM))
+		}
+		maps.Copy(devices, mountDevices(launchSettings, shift))
+		if launchSettings.DataVolume {
+			devices["data"] = dataVolumeDevice(launchSettings, dataPool, application.DataDir)
+		}
+		// the catalog devices are in the application profile, the USB and
+		// serial devices, mounts and data volume chosen here are per instance
+		err = addInstanceDevices(server, launchSettings.Name, devices)
+		if err != nil {
+			fmt.Println("Error adding devices to instance:", err)
+			os.Exit(1)
 		}
 		err = c.global.client.StartInstance(context.Background(), launchSettings.Name)
 		if err != nil {
 			fmt.Println("Error starting instance:", err)
 			os.Exit(1)
 		}
-		if launchSettings.VM {
-			log.Info("VM started, waiting for agent...")
-			const maxAttempts = 5
-			const waitTime = 2
-			getState := func() (bool, error) {
-				time.Sleep(waitTime * time.Second)
-				state, err := c.global.client.InstanceState(context.Background(), launchSettings.Name)
-				if err != nil {
-					fmt.Println("Error waiting for vm agent:", err)
-					return false, err
-				}
-				if state.State.Processes > 2 {
-					return true, nil
-				}
-				return false, nil
-			}
-			attempts := 0
-			for {
-				success, err := getState()
-				if err != nil {
-					fmt.Println("Error waiting for vm agent:", err)
-					os.Exit(1)
-				}
-				if success {
-					break
-				}
-				attempts++
-				if attempts >= maxAttempts {
-					fmt.Println("Error waiting for vm agent: max attempts reached")
-					os.Exit(1)
-				}
+		if c.useCloudInit(launchSettings) {
+			// cloud-init runs the installer, waitCloudInit waits for it
+			return
+		}
+		// public names may not resolve behind a proxy
+		err = waitReady(server, launchSettings.Name, c.flagWaitTimeout, launchSettings.Proxy == "")
+		if err != nil {
+			fmt.Println("Error waiting for instance:", err)
+			os.Exit(1)
+		}
+		if launchSettings.Proxy != "" && c.flagFromImage == "" {
+			err = configureAptProxy(server, launchSettings.Name, launchSettings.Proxy)
+			if err != nil {
+				fmt.Println("Error configuring apt proxy:", err)
+				os.Exit(1)
 			}
 		}
 	}
 
 	if doit {
 		_ = spinner.New().Title("Creating instance...").Accessible(accessible).Action(createInstance).Run()
-		installFunc, err := downloadRaw(repository, "install", application.Slug+"-install.sh")
+		if c.flagFromImage != "" {
+			server, err := c.global.server()
+			if err != nil {
+				return err
+			}
+			log.Info("Setting up instance...")
+			err = setupInstance(server, launchSettings)
+			if err != nil {
+				return err
+			}
+			out, _ := WelcomeMessage(*application, launchSettings)
+			output, _ := glamour.Render(out, "dark")
+			fmt.Print(output)
+			return nil
+		}
+		if c.useCloudInit(launchSettings) {
+			server, err := c.global.server()
+			if err != nil {
+				return err
+			}
+			err = waitCloudInit(server, launchSettings.Name, c.flagCloudInitTimeout)
+			// the user data holds the root password and installer settings,
+			// it goes also when the install failed or timed out
+			unsetErr := unsetInstanceConfig(server, launchSettings.Name, "cloud-init.user-data")
+			if err != nil {
+				if unsetErr != nil {
+					log.Error("Failed to remove the installer settings, remove cloud-init.user-data from the instance", "instance", launchSettings.Name, "error", unsetErr)
+				}
+				return err
+			}
+			if unsetErr != nil {
+				return unsetErr
+			}
+			if launchSettings.Proxy != "" && !launchSettings.PersistProxy {
+				err = removeProxy(server, launchSettings.Name)
+				if err != nil {
+					return err
+				}
+			}
+			out, _ := WelcomeMessage(*application, launchSettings)
+			output, _ := glamour.Render(out, "dark")
+			fmt.Print(output)
+			return nil
+		}
+		installFunc, err := source.download(application.Installer())
+		if errors.Is(err, errNotFound) {
+			err = fmt.Errorf("install script for '%s' not found in catalog", application.Slug)
+		}
 		if err != nil {
 			fmt.Println("Error downloading install script:", err)
 			os.Exit(1)
@@ -691,8 +1014,7 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 		}
 		log.Info("Running installer...")
 
-		insFunc := string(installFunc)
-		insFunc = strings.ReplaceAll(insFunc, "/dev/stdin <<<", "")
+		insFunc := installerScript(installFunc)
 		// run installer
 		command := exec.Command("incus", "exec", launchSettings.Name, "--", "bash", "-c", string(insFunc))
 		command.Stdin = os.Stdin
@@ -705,6 +1027,17 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 			fmt.Println("Error executing installer:", err)
 			os.Exit(1)
 		}
+		if launchSettings.Proxy != "" && !launchSettings.PersistProxy {
+			log.Info("Removing proxy settings from instance...")
+			server, err := c.global.server()
+			if err == nil {
+				err = removeProxy(server, launchSettings.Name)
+			}
+			if err != nil {
+				fmt.Println("Error removing proxy settings:", err)
+				return err
+			}
+		}
 
 		// print the summary
 		out, _ := WelcomeMessage(*application, launchSettings)
@@ -730,23 +1063,55 @@ func (c *cmdLaunch) launch(app string, instanceName string) error {
 	return nil
 }
 
-func disableSecureBoot(imagename string) bool {
-	return strings.Contains(imagename, "archlinux")
-
+// applyResourceFlags overrides the catalog resources with --cpu, --memory and --disk.
+func (c *cmdLaunch) applyResourceFlags(settings *LaunchSettings) error {
+	if c.flagCPU < 0 {
+		return fmt.Errorf("invalid --cpu %d", c.flagCPU)
+	}
+	if c.flagCPU > 0 {
+		settings.CPU = c.flagCPU
+	}
+	if c.flagMemory != "" {
+		err := validateDiskSize(c.flagMemory)
+		if err != nil {
+			return fmt.Errorf("invalid --memory: %w", err)
+		}
+		settings.RAM = c.flagMemory
+	}
+	if c.flagDisk != "" {
+		err := validateDiskSize(c.flagDisk)
+		if err != nil {
+			return fmt.Errorf("invalid --disk: %w", err)
+		}
+		settings.RootDiskSize = c.flagDisk
+	}
+	return nil
 }
 
-func getAppMetadata(app string) (*Application, error) {
-	log.Debug("Downloading application metadata", "application", app)
-	appJson, err := downloadRaw(repository, "json", app+".json")
-	if err != nil {
-		log.Error("Failed to download application metadata:", "error", err)
-		return nil, err
+// selectInstallMethod finds an install method by its type or its index.
+func selectInstallMethod(methods []InstallMethods, value string) (int, error) {
+	if i, err := strconv.Atoi(value); err == nil {
+		if i < 0 || i >= len(methods) {
+			return 0, fmt.Errorf("install method %d does not exist, there are %d", i, len(methods))
+		}
+		return i, nil
 	}
-	var application Application
-	err = json.Unmarshal(appJson, &application)
-	if err != nil {
-		log.Error("Failed to parse application metadata:", "error", err)
-		return nil, err
+	types := make([]string, len(methods))
+	for i, m := range methods {
+		if strings.EqualFold(m.Type, value) {
+			return i, nil
+		}
+		types[i] = m.Type
 	}
-	return &application, nil
+	return 0, fmt.Errorf("install method %q does not exist, choose one of %s", value, strings.Join(types, ", "))
+}
+
+// useCloudInit reports whether a VM is provisioned with cloud-init.
+func (c *cmdLaunch) useCloudInit(settings LaunchSettings) bool {
+	return settings.VM && c.flagProvision == provisionCloudInit
+}
+
+func disableSecureBoot(imagename string) bool {
+	return strings.Contains(imagename, "archlinux")
+
 }
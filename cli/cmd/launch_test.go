@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func Test_selectInstallMethod(t *testing.T) {
+	methods := []InstallMethods{{Type: "default"}, {Type: "alpine"}, {Type: "ubuntu"}}
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"type", "alpine", 1, false},
+		{"typeCase", "Ubuntu", 2, false},
+		{"index", "2", 2, false},
+		{"indexOutOfRange", "3", 0, true},
+		{"negative", "-1", 0, true},
+		{"unknownType", "fedora", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectInstallMethod(methods, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectInstallMethod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("selectInstallMethod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
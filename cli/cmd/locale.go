@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/viper"
+)
+
+// defaultTimezone is used when the host timezone cannot be detected.
+const defaultTimezone = "Etc/UTC"
+
+// aptCacherPort is the port apt-cacher-ng listens on, install.func assumes it.
+const aptCacherPort = "3142"
+
+// validateTimezone accepts IANA timezone names like Europe/Berlin.
+func validateTimezone(tz string) error {
+	if tz == "" || tz == "Local" || filepath.IsAbs(tz) {
+		return fmt.Errorf("invalid timezone %q", tz)
+	}
+	_, err := time.LoadLocation(tz)
+	if err != nil {
+		return fmt.Errorf("unknown timezone %q", tz)
+	}
+	return nil
+}
+
+// zoneFromLocaltime returns the timezone name of an /etc/localtime link
+// target like /usr/share/zoneinfo/Europe/Berlin.
+func zoneFromLocaltime(target string) string {
+	_, zone, ok := strings.Cut(target, "zoneinfo/")
+	if !ok {
+		return ""
+	}
+	return zone
+}
+
+// hostTimezone detects the timezone of the host from TZ, /etc/timezone or the
+// /etc/localtime link.
+func hostTimezone() string {
+	candidates := []string{strings.TrimPrefix(os.Getenv("TZ"), ":")}
+	if bb, err := os.ReadFile("/etc/timezone"); err == nil {
+		candidates = append(candidates, strings.TrimSpace(string(bb)))
+	}
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		candidates = append(candidates, zoneFromLocaltime(target))
+	}
+	for _, tz := range candidates {
+		if validateTimezone(tz) == nil {
+			return tz
+		}
+	}
+	return defaultTimezone
+}
+
+var localePattern = regexp.MustCompile(`^([a-z]{2,3}(_[A-Z]{2})?|C|POSIX)(\.[A-Za-z0-9-]+)?(@[a-z]+)?$`)
+
+// validateLocale accepts locale names like en_US.UTF-8 or C.UTF-8.
+func validateLocale(locale string) error {
+	if !localePattern.MatchString(locale) {
+		return fmt.Errorf("invalid locale %q, use a name like en_US.UTF-8", locale)
+	}
+	return nil
+}
+
+// checkAptCacher confirms that an apt-cacher-ng proxy accepts connections.
+func checkAptCacher(host string, timeout time.Duration) error {
+	if strings.ContainsAny(host, "/:") && net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid apt-cacher %q, use the address of the apt-cacher-ng host", host)
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, aptCacherPort), timeout)
+	if err != nil {
+		return fmt.Errorf("apt-cacher-ng is not reachable at %s: %w", net.JoinHostPort(host, aptCacherPort), err)
+	}
+	return conn.Close()
+}
+
+// applyEnvironmentFlags sets the timezone, locale and apt-cacher of a launch
+// from the flags, the configuration file or the host. A configured apt-cacher
+// that is not reachable is skipped, one given with --apt-cacher is an error.
+func (c *cmdLaunch) applyEnvironmentFlags(settings *LaunchSettings) error {
+	settings.Timezone = c.flagTimezone
+	if settings.Timezone == "" {
+		settings.Timezone = viper.GetString("timezone")
+	}
+	if settings.Timezone == "" {
+		settings.Timezone = hostTimezone()
+	}
+	err := validateTimezone(settings.Timezone)
+	if err != nil {
+		return err
+	}
+
+	settings.Locale = c.flagLocale
+	if settings.Locale == "" {
+		settings.Locale = viper.GetString("locale")
+	}
+	if settings.Locale != "" {
+		err = validateLocale(settings.Locale)
+		if err != nil {
+			return err
+		}
+	}
+
+	settings.AptCacher = c.flagAptCacher
+	if settings.AptCacher != "" {
+		return checkAptCacher(settings.AptCacher, 3*time.Second)
+	}
+	settings.AptCacher = viper.GetString("apt-cacher")
+	if settings.AptCacher != "" {
+		err = checkAptCacher(settings.AptCacher, 3*time.Second)
+		if err != nil {
+			log.Warn("Installing without the configured apt-cacher", "error", err)
+			settings.AptCacher = ""
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func Test_zoneFromLocaltime(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"absolute", "/usr/share/zoneinfo/Europe/Berlin", "Europe/Berlin"},
+		{"relative", "../usr/share/zoneinfo/America/New_York", "America/New_York"},
+		{"not a zone", "/etc/custom-localtime", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zoneFromLocaltime(tt.target); got != tt.want {
+				t.Errorf("zoneFromLocaltime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateLocale(t *testing.T) {
+	tests := []struct {
+		locale  string
+		wantErr bool
+	}{
+		{"en_US.UTF-8", false},
+		{"de_DE.UTF-8", false},
+		{"C.UTF-8", false},
+		{"sr_RS@latin", false},
+		{"en", false},
+		{"UTF-8", true},
+		{"en_US.UTF-8; rm -rf /", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			err := validateLocale(tt.locale)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLocale() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
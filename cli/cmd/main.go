@@ -5,6 +5,8 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"path/filepath"
+	"time"
 
 	"github.com/bketelsen/inclient"
 	goversion "github.com/bketelsen/toolbox/go-version"
@@ -76,8 +78,17 @@ Support information at https://github.com/bketelsen/IncusScripts/
 	// Wrappers
 	app.PersistentPreRunE = globalCmd.PreRun
 
+	cobra.OnInitialize(initConfig)
+	viper.SetDefault("image-remote", "images")
+	app.PersistentFlags().StringVar(&configFile, "config", "", "configuration file (default "+filepath.Join(configDir(), "config.yaml")+")")
 	app.PersistentFlags().StringVar(&repository, "repository", "github.com/bketelsen/IncusScripts", "script source repository")
 	viper.BindPFlag("repository", app.PersistentFlags().Lookup("repository"))
+	app.PersistentFlags().Duration("http-timeout", 30*time.Second, "timeout for each download attempt")
+	viper.BindPFlag("http-timeout", app.PersistentFlags().Lookup("http-timeout"))
+	app.PersistentFlags().Int("http-retries", 3, "number of retries for failed downloads")
+	viper.BindPFlag("http-retries", app.PersistentFlags().Lookup("http-retries"))
+	app.PersistentFlags().String("ca-bundle", "", "PEM file with additional certificate authorities to trust for downloads")
+	viper.BindPFlag("ca-bundle", app.PersistentFlags().Lookup("ca-bundle"))
 	// Version handling
 	app.SetVersionTemplate("{{.Version}}\n")
 
@@ -90,6 +101,27 @@ Support information at https://github.com/bketelsen/IncusScripts/
 	searchCmd := cmdSearch{global: &globalCmd}
 	app.AddCommand(searchCmd.Command())
 
+	infoCmd := cmdInfo{global: &globalCmd}
+	app.AddCommand(infoCmd.Command())
+
+	imagesCmd := cmdImages{global: &globalCmd}
+	app.AddCommand(imagesCmd.Command())
+
+	publishCmd := cmdPublish{global: &globalCmd}
+	app.AddCommand(publishCmd.Command())
+
+	bundleCmd := cmdBundle{global: &globalCmd}
+	app.AddCommand(bundleCmd.Command())
+
+	devicesCmd := cmdDevices{global: &globalCmd}
+	app.AddCommand(devicesCmd.Command())
+
+	profileCmd := cmdProfile{global: &globalCmd}
+	app.AddCommand(profileCmd.Command())
+
+	catalogCmd := cmdCatalog{global: &globalCmd}
+	app.AddCommand(catalogCmd.Command())
+
 	docsCmd := cmdDocs{global: &globalCmd}
 	app.AddCommand(docsCmd.Command())
 
@@ -130,6 +162,11 @@ func (c *cmdGlobal) PreRun(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	// Commands that only work on the repository don't need incus
+	if cmd.Annotations[annotationNoIncus] == "true" {
+		return nil
+	}
+
 	// Figure out the config directory and config path
 	var configDir string
 	if os.Getenv("INCUS_CONF") != "" {
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// hostMount bind-mounts a host path into an instance.
+type hostMount struct {
+	Source   string `json:"source"`
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+// volumeMount attaches a custom storage volume to an instance.
+type volumeMount struct {
+	Pool   string `json:"pool"`
+	Volume string `json:"volume"`
+	Path   string `json:"path"`
+}
+
+// parseMount parses <host path>:<instance path>[:ro].
+func parseMount(value string) (hostMount, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "ro") {
+		return hostMount{}, fmt.Errorf("invalid mount %q, use <host path>:<instance path>[:ro]", value)
+	}
+	m := hostMount{Source: parts[0], Path: parts[1], ReadOnly: len(parts) == 3}
+	if !path.IsAbs(m.Source) || !path.IsAbs(m.Path) {
+		return hostMount{}, fmt.Errorf("invalid mount %q, both paths must be absolute", value)
+	}
+	if path.Clean(m.Path) == "/" {
+		return hostMount{}, fmt.Errorf("invalid mount %q, cannot mount over /", value)
+	}
+	return m, nil
+}
+
+// parseVolume parses <pool>/<volume>:<instance path>.
+func parseVolume(value string) (volumeMount, error) {
+	volume, target, ok := strings.Cut(value, ":")
+	pool, name, found := strings.Cut(volume, "/")
+	if !ok || !found || pool == "" || name == "" || strings.Contains(name, "/") {
+		return volumeMount{}, fmt.Errorf("invalid volume %q, use <pool>/<volume>:<instance path>", value)
+	}
+	if !path.IsAbs(target) || path.Clean(target) == "/" {
+		return volumeMount{}, fmt.Errorf("invalid volume %q, the instance path must be absolute and not /", value)
+	}
+	return volumeMount{Pool: pool, Volume: name, Path: target}, nil
+}
+
+// parseLines parses one value per line with parse, skipping empty lines.
+func parseLines[T any](text string, parse func(string) (T, error)) ([]T, error) {
+	var values []T
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		v, err := parse(line)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// dataVolumeName is the custom volume launch creates for the data directory
+// of an application.
+func dataVolumeName(instance string) string {
+	return instance + "-data"
+}
+
+// mountDevices builds the disk devices for the mounts and volumes of a launch.
+// With shift, host paths are shifted in unprivileged containers so they can
+// write to them, custom volumes are shifted by incus.
+func mountDevices(settings LaunchSettings, shift bool) map[string]map[string]string {
+	devices := map[string]map[string]string{}
+	for i, m := range settings.Mounts {
+		device := map[string]string{"type": "disk", "source": m.Source, "path": m.Path}
+		if m.ReadOnly {
+			device["readonly"] = "true"
+		}
+		if shift && !settings.VM && !settings.Privileged {
+			device["shift"] = "true"
+		}
+		devices[fmt.Sprintf("mount-%d", i)] = device
+	}
+	for _, v := range settings.Volumes {
+		devices["volume-"+v.Volume] = map[string]string{"type": "disk", "pool": v.Pool, "source": v.Volume, "path": v.Path}
+	}
+	return devices
+}
+
+// ensureVolume creates a custom storage volume unless it exists.
+func ensureVolume(server incus.InstanceServer, pool string, name string) error {
+	_, _, err := server.GetStoragePoolVolume(pool, "custom", name)
+	if err == nil {
+		return nil
+	}
+	log.Info("Creating storage volume", "pool", pool, "volume", name)
+	err = server.CreateStoragePoolVolume(pool, api.StorageVolumesPost{Name: name, Type: "custom"})
+	if err != nil {
+		return fmt.Errorf("failed to create storage volume %s/%s: %w", pool, name, err)
+	}
+	return nil
+}
+
+// canShift reports whether host paths can be shifted for an unprivileged
+// container. Incus refuses to start containers with shifted mounts when the
+// kernel lacks idmapped mounts, so they are then mounted unshifted, and the
+// container sees the files owned by nobody.
+func (c *cmdGlobal) canShift(settings LaunchSettings) (bool, error) {
+	if settings.VM || settings.Privileged || len(settings.Mounts) == 0 {
+		return true, nil
+	}
+	server, err := c.server()
+	if err != nil {
+		return false, err
+	}
+	info, _, err := server.GetServer()
+	if err != nil {
+		return false, err
+	}
+	if info.Environment.KernelFeatures["idmapped_mounts"] != "true" {
+		log.Warn("The kernel does not support idmapped mounts, host paths are mounted without shifting: the container sees their files owned by nobody and can only write to files writable by others")
+		return false, nil
+	}
+	return true, nil
+}
+
+// mountsForm asks for host paths and storage volumes to mount, and whether
+// to keep the data directory of the application on its own volume.
+func mountsForm(settings *LaunchSettings, dataDir string, accessible bool) error {
+	var mounts, volumes []string
+	for _, m := range settings.Mounts {
+		value := m.Source + ":" + m.Path
+		if m.ReadOnly {
+			value += ":ro"
+		}
+		mounts = append(mounts, value)
+	}
+	for _, v := range settings.Volumes {
+		volumes = append(volumes, v.Pool+"/"+v.Volume+":"+v.Path)
+	}
+	mountText := strings.Join(mounts, "\n")
+	volumeText := strings.Join(volumes, "\n")
+
+	fields := []huh.Field{
+		huh.NewText().
+			Value(&mountText).
+			Title("Host Paths").
+			Description("One <host path>:<instance path>[:ro] per line, for example /srv/media:/media:ro.").
+			Validate(func(s string) error {
+				_, err := parseLines(s, parseMount)
+				return err
+			}),
+		huh.NewText().
+			Value(&volumeText).
+			Title("Storage Volumes").
+			Description("One <pool>/<volume>:<instance path> per line. Missing volumes are created.").
+			Validate(func(s string) error {
+				_, err := parseLines(s, parseVolume)
+				return err
+			}),
+	}
+	if dataDir != "" {
+		fields = append(fields,
+			huh.NewConfirm().
+				Title("Keep "+dataDir+" on a storage volume?").
+				Value(&settings.DataVolume).
+				Affirmative("Yes").
+				Negative("No").
+				Description("The application data outlives the instance in volume "+dataVolumeName(settings.Name)+"."),
+		)
+	}
+	err := huh.NewForm(huh.NewGroup(fields...)).WithAccessible(accessible).Run()
+	if err != nil {
+		return err
+	}
+	settings.Mounts, err = parseLines(mountText, parseMount)
+	if err != nil {
+		return err
+	}
+	settings.Volumes, err = parseLines(volumeText, parseVolume)
+	return err
+}
+
+// ensureVolumes creates the missing storage volumes of a launch, including
+// the data volume on dataPool.
+func ensureVolumes(server incus.InstanceServer, settings LaunchSettings, dataPool string) error {
+	for _, v := range settings.Volumes {
+		err := ensureVolume(server, v.Pool, v.Volume)
+		if err != nil {
+			return err
+		}
+	}
+	if settings.DataVolume {
+		return ensureVolume(server, dataPool, dataVolumeName(settings.Name))
+	}
+	return nil
+}
+
+// dataVolumeDevice mounts the data volume of an instance on the data
+// directory of its application.
+func dataVolumeDevice(settings LaunchSettings, dataPool string, dataDir string) map[string]string {
+	return map[string]string{"type": "disk", "pool": dataPool, "source": dataVolumeName(settings.Name), "path": dataDir}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseMount(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    hostMount
+		wantErr bool
+	}{
+		{"/srv/media:/media", hostMount{Source: "/srv/media", Path: "/media"}, false},
+		{"/srv/media:/media:ro", hostMount{Source: "/srv/media", Path: "/media", ReadOnly: true}, false},
+		{"/srv/media:/media:rw", hostMount{}, true},
+		{"srv/media:/media", hostMount{}, true},
+		{"/srv/media:/", hostMount{}, true},
+		{"/srv/media", hostMount{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseMount(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseMount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseVolume(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    volumeMount
+		wantErr bool
+	}{
+		{"default/downloads:/downloads", volumeMount{Pool: "default", Volume: "downloads", Path: "/downloads"}, false},
+		{"downloads:/downloads", volumeMount{}, true},
+		{"default/downloads", volumeMount{}, true},
+		{"default/a/b:/downloads", volumeMount{}, true},
+		{"default/downloads:downloads", volumeMount{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseVolume(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVolume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseVolume() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mountDevices(t *testing.T) {
+	settings := LaunchSettings{
+		Mounts:  []hostMount{{Source: "/srv/media", Path: "/media", ReadOnly: true}},
+		Volumes: []volumeMount{{Pool: "default", Volume: "downloads", Path: "/downloads"}},
+	}
+	want := map[string]map[string]string{
+		"mount-0":          {"type": "disk", "source": "/srv/media", "path": "/media", "readonly": "true", "shift": "true"},
+		"volume-downloads": {"type": "disk", "pool": "default", "source": "downloads", "path": "/downloads"},
+	}
+	if got := mountDevices(settings, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("mountDevices() = %v, want %v", got, want)
+	}
+
+	delete(want["mount-0"], "shift")
+	if got := mountDevices(settings, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("mountDevices() without shifting = %v, want %v", got, want)
+	}
+
+	settings.VM = true
+	if got := mountDevices(settings, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("mountDevices() for a VM = %v, want %v", got, want)
+	}
+}
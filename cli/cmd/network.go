@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/charmbracelet/huh"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// NIC types for host interfaces that are not managed by incus.
+const (
+	nicBridged  = "bridged"
+	nicMacvlan  = "macvlan"
+	nicPhysical = "physical"
+)
+
+// hostInterfaceTypes are the unmanaged network types an instance can be
+// attached to.
+var hostInterfaceTypes = []string{"physical", "bond", "vlan", "bridge"}
+
+// staticAddressTypes are the managed network types that hand out static
+// addresses.
+var staticAddressTypes = []string{"bridge", "ovn"}
+
+// attachable reports whether an instance can be attached to a network.
+func attachable(n api.Network) bool {
+	return n.Managed || slices.Contains(hostInterfaceTypes, n.Type)
+}
+
+// networkLabel describes a network for the network picker.
+func networkLabel(n api.Network) string {
+	if !n.Managed {
+		return fmt.Sprintf("%s (host %s interface)", n.Name, n.Type)
+	}
+	if subnet := n.Config["ipv4.address"]; subnet != "" && subnet != "none" {
+		return fmt.Sprintf("%s (%s, %s)", n.Name, n.Type, subnet)
+	}
+	return fmt.Sprintf("%s (%s)", n.Name, n.Type)
+}
+
+// hasNetworkSettings reports whether any network option was chosen.
+func hasNetworkSettings(settings LaunchSettings) bool {
+	return settings.Network != "" || settings.NICType != "" || settings.IPv4Address != "" ||
+		settings.IPv6Address != "" || settings.VLAN != 0
+}
+
+// ipv6Enabled reports whether the installer should leave IPv6 enabled.
+func ipv6Enabled(settings LaunchSettings) bool {
+	return settings.IPv6 || settings.IPv6Address != ""
+}
+
+// validateStaticAddress checks that a static address of the given IP family
+// is a usable host address in the subnet of a network, given as the
+// network's ipv4.address or ipv6.address.
+func validateStaticAddress(addr string, subnet string, family int) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("%q is not an IP address", addr)
+	}
+	if (family == 4) != (ip.To4() != nil) {
+		return fmt.Errorf("%s is not an IPv%d address", addr, family)
+	}
+	if subnet == "" || subnet == "none" {
+		return fmt.Errorf("the network has no IPv%d subnet", family)
+	}
+	gateway, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("the network subnet %q is invalid: %w", subnet, err)
+	}
+	if !ipnet.Contains(ip) {
+		return fmt.Errorf("%s is outside the network subnet %s", addr, ipnet)
+	}
+	if ip.Equal(gateway) {
+		return fmt.Errorf("%s is the network gateway", addr)
+	}
+	if ip.Equal(ipnet.IP) {
+		return fmt.Errorf("%s is the subnet address", addr)
+	}
+	if family == 4 {
+		broadcast := make(net.IP, len(ipnet.IP))
+		for i := range ipnet.IP {
+			broadcast[i] = ipnet.IP[i] | ^ipnet.Mask[i]
+		}
+		if ip.Equal(broadcast) {
+			return fmt.Errorf("%s is the broadcast address", addr)
+		}
+	}
+	return nil
+}
+
+// nicDevice builds the network interface of an instance on the given
+// network, checking the static addresses and VLAN against it.
+func nicDevice(settings LaunchSettings, network api.Network) (map[string]string, error) {
+	device := map[string]string{"type": "nic"}
+	if network.Managed {
+		if settings.NICType != "" {
+			return nil, fmt.Errorf("the NIC type can only be chosen for host interfaces, %s is a managed network", network.Name)
+		}
+		device["network"] = network.Name
+	} else {
+		nicType := settings.NICType
+		if nicType == "" {
+			nicType = nicMacvlan
+			if network.Type == "bridge" {
+				nicType = nicBridged
+			}
+		}
+		switch nicType {
+		case nicMacvlan, nicPhysical:
+		case nicBridged:
+			if network.Type != "bridge" {
+				return nil, fmt.Errorf("%s is not a bridge, use %s or %s", network.Name, nicMacvlan, nicPhysical)
+			}
+		default:
+			return nil, fmt.Errorf("invalid NIC type %q, use %s or %s", nicType, nicMacvlan, nicPhysical)
+		}
+		device["nictype"] = nicType
+		device["parent"] = network.Name
+	}
+
+	if settings.IPv4Address != "" || settings.IPv6Address != "" {
+		if !network.Managed || !slices.Contains(staticAddressTypes, network.Type) {
+			return nil, fmt.Errorf("static addresses need a managed bridge or OVN network, %s is not one", network.Name)
+		}
+	}
+	if settings.IPv4Address != "" {
+		err := validateStaticAddress(settings.IPv4Address, network.Config["ipv4.address"], 4)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IPv4 address for %s: %w", network.Name, err)
+		}
+		device["ipv4.address"] = settings.IPv4Address
+	}
+	if settings.IPv6Address != "" {
+		err := validateStaticAddress(settings.IPv6Address, network.Config["ipv6.address"], 6)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IPv6 address for %s: %w", network.Name, err)
+		}
+		device["ipv6.address"] = settings.IPv6Address
+	}
+
+	if settings.VLAN != 0 {
+		if settings.VLAN < 1 || settings.VLAN > 4094 {
+			return nil, fmt.Errorf("invalid VLAN %d, use 1 to 4094", settings.VLAN)
+		}
+		if network.Type == "ovn" {
+			return nil, fmt.Errorf("%s is an OVN network, which does not support VLAN tags", network.Name)
+		}
+		device["vlan"] = strconv.Itoa(settings.VLAN)
+	}
+	return device, nil
+}
+
+// profileNIC returns the name and settings of the first network interface
+// in the given profiles, or eth0 when they have none.
+func profileNIC(server incus.InstanceServer, profiles []string) (string, map[string]string, error) {
+	devices := map[string]map[string]string{}
+	for _, name := range profiles {
+		profile, _, err := server.GetProfile(name)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to get profile %s: %w", name, err)
+		}
+		for k, d := range profile.Devices {
+			devices[k] = d
+		}
+	}
+	var names []string
+	for k, d := range devices {
+		if d["type"] == "nic" {
+			names = append(names, k)
+		}
+	}
+	if len(names) == 0 {
+		return "eth0", nil, nil
+	}
+	sort.Strings(names)
+	return names[0], devices[names[0]], nil
+}
+
+// networkDevice builds the network interface for the network settings of a
+// launch. It replaces the interface of the profiles, and is nil when no
+// network option was chosen.
+func (c *cmdGlobal) networkDevice(settings LaunchSettings) (string, map[string]string, error) {
+	if !hasNetworkSettings(settings) {
+		return "", nil, nil
+	}
+	server, err := c.server()
+	if err != nil {
+		return "", nil, err
+	}
+	profiles := settings.Profiles
+	if len(profiles) == 0 {
+		profiles = []string{"default"}
+	}
+	name, current, err := profileNIC(server, profiles)
+	if err != nil {
+		return "", nil, err
+	}
+	networkName := settings.Network
+	if networkName == "" {
+		networkName = current["network"]
+	}
+	if networkName == "" {
+		networkName = current["parent"]
+	}
+	if networkName == "" {
+		return "", nil, errors.New("the profiles have no network interface, choose a network with --network")
+	}
+	network, _, err := server.GetNetwork(networkName)
+	if err != nil {
+		return "", nil, fmt.Errorf("network %q does not exist", networkName)
+	}
+	device, err := nicDevice(settings, *network)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, device, nil
+}
+
+// networkForm asks for the network of the instance, its static addresses,
+// VLAN and IPv6.
+func networkForm(settings *LaunchSettings, networks []api.Network, accessible bool) error {
+	var options []huh.Option[string]
+	byName := map[string]api.Network{}
+	for _, n := range networks {
+		if attachable(n) {
+			options = append(options, huh.NewOption(networkLabel(n), n.Name))
+			byName[n.Name] = n
+		}
+	}
+	if len(options) == 0 {
+		return errors.New("no networks found")
+	}
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Value(&settings.Network).
+				Title("Choose Network").
+				Options(options...).
+				Description("Managed bridges and OVN networks, or host interfaces for macvlan and physical NICs."),
+		),
+	).WithAccessible(accessible)
+	err := form.Run()
+	if err != nil {
+		return err
+	}
+	network := byName[settings.Network]
+
+	if !network.Managed && network.Type != "bridge" {
+		form = huh.NewForm(
+			huh.NewGroup(
+				huh.NewSelect[string]().
+					Value(&settings.NICType).
+					Title("Choose NIC Type").
+					Options(
+						huh.NewOption("macvlan, share the interface with the host", nicMacvlan),
+						huh.NewOption("physical, move the interface into the instance", nicPhysical),
+					),
+			),
+		).WithAccessible(accessible)
+		err = form.Run()
+		if err != nil {
+			return err
+		}
+	}
+
+	vlan := ""
+	if settings.VLAN != 0 {
+		vlan = strconv.Itoa(settings.VLAN)
+	}
+	fields := []huh.Field{}
+	if network.Managed && slices.Contains(staticAddressTypes, network.Type) {
+		fields = append(fields,
+			huh.NewInput().
+				Value(&settings.IPv4Address).
+				Title("Static IPv4 Address").
+				Placeholder(network.Config["ipv4.address"]).
+				Description("Leave empty to use DHCP.").
+				Validate(func(s string) error {
+					if s == "" {
+						return nil
+					}
+					return validateStaticAddress(s, network.Config["ipv4.address"], 4)
+				}),
+			huh.NewInput().
+				Value(&settings.IPv6Address).
+				Title("Static IPv6 Address").
+				Placeholder(network.Config["ipv6.address"]).
+				Description("Leave empty to use SLAAC or DHCPv6.").
+				Validate(func(s string) error {
+					if s == "" {
+						return nil
+					}
+					return validateStaticAddress(s, network.Config["ipv6.address"], 6)
+				}),
+		)
+	}
+	if network.Type != "ovn" {
+		fields = append(fields,
+			huh.NewInput().
+				Value(&vlan).
+				Title("VLAN").
+				Description("VLAN tag of the interface. Leave empty for untagged traffic.").
+				Validate(func(s string) error {
+					if s == "" {
+						return nil
+					}
+					v, err := strconv.Atoi(s)
+					if err != nil || v < 1 || v > 4094 {
+						return errors.New("VLAN must be a number from 1 to 4094")
+					}
+					return nil
+				}),
+		)
+	}
+	fields = append(fields,
+		huh.NewConfirm().
+			Title("Enable IPv6?").
+			Value(&settings.IPv6).
+			Affirmative("Yes").
+			Negative("No").
+			Description("The installer disables IPv6 in the instance unless it is enabled."),
+	)
+	form = huh.NewForm(huh.NewGroup(fields...)).WithAccessible(accessible)
+	err = form.Run()
+	if err != nil {
+		return err
+	}
+	settings.VLAN = 0
+	if vlan != "" {
+		settings.VLAN, _ = strconv.Atoi(vlan)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+func Test_validateStaticAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		subnet  string
+		family  int
+		wantErr bool
+	}{
+		{"inside", "10.10.10.50", "10.10.10.1/24", 4, false},
+		{"outside", "10.10.11.50", "10.10.10.1/24", 4, true},
+		{"gateway", "10.10.10.1", "10.10.10.1/24", 4, true},
+		{"subnet address", "10.10.10.0", "10.10.10.1/24", 4, true},
+		{"broadcast", "10.10.10.255", "10.10.10.1/24", 4, true},
+		{"no subnet", "10.10.10.50", "none", 4, true},
+		{"wrong family", "fd42::50", "10.10.10.1/24", 4, true},
+		{"not an address", "grafana", "10.10.10.1/24", 4, true},
+		{"ipv6", "fd42:1:2:3::50", "fd42:1:2:3::1/64", 6, false},
+		{"ipv6 outside", "fd42:9::50", "fd42:1:2:3::1/64", 6, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStaticAddress(tt.addr, tt.subnet, tt.family)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateStaticAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_nicDevice(t *testing.T) {
+	bridge := api.Network{Name: "incusbr0", Type: "bridge", Managed: true, NetworkPut: api.NetworkPut{Config: map[string]string{"ipv4.address": "10.10.10.1/24"}}}
+	ovn := api.Network{Name: "ovn0", Type: "ovn", Managed: true}
+	host := api.Network{Name: "enp3s0", Type: "physical"}
+	hostBridge := api.Network{Name: "br0", Type: "bridge"}
+	tests := []struct {
+		name     string
+		settings LaunchSettings
+		network  api.Network
+		want     map[string]string
+		wantErr  bool
+	}{
+		{"managed", LaunchSettings{IPv4Address: "10.10.10.50", VLAN: 20}, bridge, map[string]string{"type": "nic", "network": "incusbr0", "ipv4.address": "10.10.10.50", "vlan": "20"}, false},
+		{"macvlan", LaunchSettings{}, host, map[string]string{"type": "nic", "nictype": "macvlan", "parent": "enp3s0"}, false},
+		{"physical", LaunchSettings{NICType: nicPhysical}, host, map[string]string{"type": "nic", "nictype": "physical", "parent": "enp3s0"}, false},
+		{"host bridge", LaunchSettings{}, hostBridge, map[string]string{"type": "nic", "nictype": "bridged", "parent": "br0"}, false},
+		{"static on host interface", LaunchSettings{IPv4Address: "10.10.10.50"}, host, nil, true},
+		{"nic type on managed", LaunchSettings{NICType: nicMacvlan}, bridge, nil, true},
+		{"vlan on ovn", LaunchSettings{VLAN: 20}, ovn, nil, true},
+		{"vlan out of range", LaunchSettings{VLAN: 5000}, host, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nicDevice(tt.settings, tt.network)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nicDevice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nicDevice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,736 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/spf13/cobra"
+)
+
+type cmdProfile struct {
+	global *cmdGlobal
+}
+
+func (c *cmdProfile) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "profile"
+	cmd.Short = "manage the incus profiles of scripts-cli"
+	cmd.Long =
+		`Manage the incus profiles of scripts-cli
+
+scripts-cli owns two kinds of profiles:
+
+  scriptcli-storage      the root disk, for hosts like TrueNAS whose default
+                         profile has none
+  scriptcli-app-<slug>   the limits, security settings and catalog devices of
+                         an application, and the GPU of GPU applications,
+                         shared by all its instances launched with
+                         --app-profile
+
+Launch creates a missing application profile but never changes an existing one,
+it fails when the profile was set up for another install method or --hardened
+value. Settings chosen for one instance, like USB devices and mounts, stay on the
+instance. Repair updates a profile in place, so instances using it keep running.`
+
+	listCmd := cmdProfileList{global: c.global}
+	cmd.AddCommand(listCmd.Command())
+
+	createCmd := cmdProfileCreate{global: c.global}
+	cmd.AddCommand(createCmd.Command())
+
+	repairCmd := cmdProfileRepair{global: c.global}
+	cmd.AddCommand(repairCmd.Command())
+
+	deleteCmd := cmdProfileDelete{global: c.global}
+	cmd.AddCommand(deleteCmd.Command())
+
+	syncCmd := cmdProfileSync{global: c.global}
+	cmd.AddCommand(syncCmd.Command())
+
+	// Workaround for subcommand usage errors. See: https://github.com/spf13/cobra/issues/706
+	cmd.Args = cobra.NoArgs
+	cmd.Run = func(cmd *cobra.Command, args []string) { _ = cmd.Usage() }
+	return cmd
+}
+
+type cmdProfileList struct {
+	global *cmdGlobal
+}
+
+func (c *cmdProfileList) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "list"
+	cmd.Short = "list the profiles of scripts-cli"
+	cmd.Args = cobra.NoArgs
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdProfileList) Run(cmd *cobra.Command, args []string) error {
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	profiles, err := server.GetProfiles()
+	if err != nil {
+		return fmt.Errorf("failed to list profiles: %w", err)
+	}
+	slices.SortFunc(profiles, func(a, b api.Profile) int { return strings.Compare(a.Name, b.Name) })
+	found := false
+	for _, p := range profiles {
+		if !managedProfile(p.Name) {
+			continue
+		}
+		found = true
+		fmt.Printf("%s | %s | used by %d\n", p.Name, p.Description, len(p.UsedBy))
+	}
+	if !found {
+		log.Info("No scripts-cli profiles")
+	}
+	return nil
+}
+
+type cmdProfileCreate struct {
+	global *cmdGlobal
+
+	flagPool          string
+	flagInstallMethod string
+	flagHardened      bool
+}
+
+func (c *cmdProfileCreate) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "create storage|<application>"
+	cmd.Short = "create the storage profile or an application profile"
+	cmd.Long =
+		`Create the storage profile or an application profile
+
+"storage" creates scriptcli-storage with a root disk on --pool, by default the pool
+named default or else the first pool. An application creates its
+scriptcli-app-<slug> profile from the catalog.`
+	cmd.Example = `  scripts-cli profile create storage --pool tank
+  scripts-cli profile create jellyfin`
+	cmd.Args = cobra.ExactArgs(1)
+	cmd.Flags().StringVar(&c.flagPool, "pool", "", "storage pool of the root disk in the storage profile")
+	cmd.Flags().StringVar(&c.flagInstallMethod, "install-method", "", "install method whose resources the application profile gets, by type or index")
+	cmd.Flags().BoolVar(&c.flagHardened, "hardened", false, "apply only the security features the application declares")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdProfileCreate) Run(cmd *cobra.Command, args []string) error {
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	if args[0] == "storage" || args[0] == storageProfileName {
+		_, _, err = server.GetProfile(storageProfileName)
+		if err == nil {
+			return fmt.Errorf("profile %s already exists, use profile repair to update it", storageProfileName)
+		}
+		_, err = c.global.ensureStorageProfile(c.flagPool)
+		return err
+	}
+
+	app, err := getAppMetadata(strings.TrimPrefix(args[0], appProfilePrefix))
+	if err != nil {
+		return err
+	}
+	_, _, err = server.GetProfile(appProfileName(app.Slug))
+	if err == nil {
+		return fmt.Errorf("profile %s already exists, use profile repair to update it", appProfileName(app.Slug))
+	}
+	i := 0
+	if c.flagInstallMethod != "" {
+		i, err = selectInstallMethod(app.InstallMethods, c.flagInstallMethod)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = c.global.ensureAppProfile(*app, app.InstallMethods[i], c.flagHardened)
+	return err
+}
+
+type cmdProfileRepair struct {
+	global *cmdGlobal
+
+	flagPool            string
+	flagAllowPrivileged bool
+}
+
+func (c *cmdProfileRepair) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "repair [<profile>...]"
+	cmd.Short = "update the profiles of scripts-cli in place"
+	cmd.Long =
+		`Update the profiles of scripts-cli in place
+
+The storage profile gets its root disk back on --pool, on its current pool when that
+still exists, or else on the default pool. Application profiles are synced with the
+catalog, like profile sync does. Without arguments every scripts-cli profile is
+repaired.`
+	cmd.Example = `  scripts-cli profile repair
+  scripts-cli profile repair scriptcli-storage --pool tank`
+	cmd.Flags().StringVar(&c.flagPool, "pool", "", "storage pool of the root disk in the storage profile")
+	cmd.Flags().BoolVar(&c.flagAllowPrivileged, "allow-privileged", false, "allow profiles to become privileged")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdProfileRepair) Run(cmd *cobra.Command, args []string) error {
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	profiles := args
+	if len(profiles) == 0 {
+		names, err := server.GetProfileNames()
+		if err != nil {
+			return fmt.Errorf("failed to list profiles: %w", err)
+		}
+		for _, name := range names {
+			if managedProfile(name) {
+				profiles = append(profiles, name)
+			}
+		}
+	}
+	for _, name := range profiles {
+		if !managedProfile(name) {
+			return fmt.Errorf("%s is not a scripts-cli profile", name)
+		}
+	}
+
+	sync := cmdProfileSync{global: c.global, flagAllowPrivileged: c.flagAllowPrivileged}
+	failed := 0
+	for _, name := range profiles {
+		if name == storageProfileName {
+			_, err = c.global.ensureStorageProfile(c.flagPool)
+		} else {
+			err = sync.sync(server, name)
+		}
+		if err != nil {
+			log.Error("Failed to repair profile", "profile", name, "error", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to repair %d of %d profiles", failed, len(profiles))
+	}
+	return nil
+}
+
+type cmdProfileDelete struct {
+	global *cmdGlobal
+}
+
+func (c *cmdProfileDelete) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "delete <profile>..."
+	cmd.Short = "delete profiles of scripts-cli"
+	cmd.Long =
+		`Delete profiles of scripts-cli
+
+Profiles used by instances are not deleted. Remove the profile from the instances, or
+delete them, first.`
+	cmd.Args = cobra.MinimumNArgs(1)
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdProfileDelete) Run(cmd *cobra.Command, args []string) error {
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	for _, name := range args {
+		if !managedProfile(name) {
+			return fmt.Errorf("%s is not a scripts-cli profile", name)
+		}
+		profile, _, err := server.GetProfile(name)
+		if err != nil {
+			return fmt.Errorf("failed to get profile %s: %w", name, err)
+		}
+		if len(profile.UsedBy) > 0 {
+			return fmt.Errorf("profile %s is used by %s", name, strings.Join(usedByNames(profile.UsedBy), ", "))
+		}
+		err = server.DeleteProfile(name)
+		if err != nil {
+			return fmt.Errorf("failed to delete profile %s: %w", name, err)
+		}
+		log.Info("Deleted profile", "profile", name)
+	}
+	return nil
+}
+
+type cmdProfileSync struct {
+	global *cmdGlobal
+
+	flagDryRun          bool
+	flagAllowPrivileged bool
+}
+
+func (c *cmdProfileSync) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "sync [<application>...]"
+	cmd.Short = "update application profiles from the catalog"
+	cmd.Long =
+		`Update application profiles from the catalog
+
+Sets the limits, security settings, kernel modules and device nodes of the
+scriptcli-app-<slug> profiles to the current catalog metadata of their applications.
+The GPU and other settings in the profiles are kept. Without arguments every
+application profile is synced.
+
+A profile is not made privileged unless --allow-privileged is given.`
+	cmd.Example = `  scripts-cli profile sync
+  scripts-cli profile sync jellyfin --dry-run`
+	cmd.Flags().BoolVar(&c.flagDryRun, "dry-run", false, "show the changes without applying them")
+	cmd.Flags().BoolVar(&c.flagAllowPrivileged, "allow-privileged", false, "allow profiles to become privileged")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdProfileSync) Run(cmd *cobra.Command, args []string) error {
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	names, err := server.GetProfileNames()
+	if err != nil {
+		return fmt.Errorf("failed to list profiles: %w", err)
+	}
+	var profiles []string
+	for _, name := range names {
+		slug, ok := strings.CutPrefix(name, appProfilePrefix)
+		if ok && (len(args) == 0 || slices.Contains(args, slug)) {
+			profiles = append(profiles, name)
+		}
+	}
+	if len(profiles) == 0 {
+		log.Info("No application profiles to sync")
+		return nil
+	}
+
+	failed := 0
+	for _, name := range profiles {
+		err = c.sync(server, name)
+		if err != nil {
+			log.Error("Failed to sync profile", "profile", name, "error", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to sync %d of %d profiles", failed, len(profiles))
+	}
+	return nil
+}
+
+func (c *cmdProfileSync) sync(server incus.InstanceServer, name string) error {
+	profile, etag, err := server.GetProfile(name)
+	if err != nil {
+		return err
+	}
+	ref := profile.Config[provenancePrefix+"app"]
+	if ref == "" {
+		ref = strings.TrimPrefix(name, appProfilePrefix)
+	}
+	if source := profile.Config[provenancePrefix+"source"]; source != "" {
+		ref = source + "/" + ref
+	}
+	app, err := getAppMetadata(ref)
+	if err != nil {
+		return err
+	}
+	method := app.InstallMethods[0]
+	if t := profile.Config[provenancePrefix+"install-method"]; t != "" {
+		i, err := selectInstallMethod(app.InstallMethods, t)
+		if err != nil {
+			return err
+		}
+		method = app.InstallMethods[i]
+	}
+	desired := appProfileConfig(*app, method, profile.Config[provenancePrefix+"hardened"] == "true")
+	if desired["security.privileged"] == "true" && profile.Config["security.privileged"] != "true" && !c.flagAllowPrivileged {
+		return fmt.Errorf("%s is now privileged in the catalog, sync with --allow-privileged to update the profile", app.Name)
+	}
+
+	config, changes := reconcileProfileConfig(profile.Config, desired)
+	devices, deviceChanges := reconcileProfileDevices(profile.Devices, securityDevices(*app, false))
+	changes = append(changes, deviceChanges...)
+	if len(changes) == 0 {
+		log.Info("Profile is up to date", "profile", name)
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Printf("%s: %s\n", name, change)
+	}
+	if c.flagDryRun {
+		return nil
+	}
+	put := profile.Writable()
+	put.Config = config
+	put.Devices = devices
+	err = server.UpdateProfile(name, put, etag)
+	if err != nil {
+		return fmt.Errorf("failed to update profile %s: %w", name, err)
+	}
+	log.Info("Synced profile", "profile", name)
+	return nil
+}
+
+// appProfilePrefix starts the names of the per-application profiles.
+const appProfilePrefix = "scriptcli-app-"
+
+// appProfileName is the profile shared by the instances of an application.
+func appProfileName(slug string) string {
+	return appProfilePrefix + slug
+}
+
+// managedProfileKey reports whether an application profile key comes from
+// the catalog, and is replaced by profile sync.
+func managedProfileKey(key string) bool {
+	return key == "limits.cpu" || key == "limits.memory" || key == "linux.kernel_modules" ||
+		strings.HasPrefix(key, "security.") || strings.HasPrefix(key, provenancePrefix)
+}
+
+// appProfileConfig is the configuration of an application profile derived
+// from the catalog: the resources of the install method and the security
+// settings, and where they came from.
+func appProfileConfig(app Application, method InstallMethods, hardened bool) map[string]string {
+	config := map[string]string{}
+	maps.Copy(config, securityConfig(app, false, hardened))
+	var size LaunchSettings
+	size.ApplyResources(method.Resources)
+	if size.CPU > 0 {
+		config["limits.cpu"] = fmt.Sprint(size.CPU)
+	}
+	if size.RAM != "" {
+		config["limits.memory"] = size.RAM
+	}
+	config[provenancePrefix+"app"] = app.Slug
+	if app.Source != "" {
+		config[provenancePrefix+"source"] = app.Source
+	}
+	config[provenancePrefix+"install-method"] = method.Type
+	if hardened {
+		config[provenancePrefix+"hardened"] = "true"
+	}
+	return config
+}
+
+// reconcileProfileConfig replaces the catalog keys of a profile configuration
+// with the desired ones and keeps the others. It returns the new
+// configuration and the changes, sorted by key.
+func reconcileProfileConfig(current map[string]string, desired map[string]string) (map[string]string, []string) {
+	config := map[string]string{}
+	keys := map[string]bool{}
+	for k, v := range current {
+		keys[k] = true
+		if !managedProfileKey(k) {
+			config[k] = v
+		}
+	}
+	for k, v := range desired {
+		keys[k] = true
+		config[k] = v
+	}
+
+	var changes []string
+	for _, k := range slices.Sorted(maps.Keys(keys)) {
+		old, had := current[k]
+		v, has := config[k]
+		switch {
+		case k == provenancePrefix+"source" || k == provenancePrefix+"install-method":
+			// bookkeeping, not worth reporting
+		case has && !had:
+			changes = append(changes, fmt.Sprintf("set %s=%s", k, v))
+		case had && !has:
+			changes = append(changes, fmt.Sprintf("unset %s (was %s)", k, old))
+		case old != v:
+			changes = append(changes, fmt.Sprintf("set %s=%s (was %s)", k, v, old))
+		}
+	}
+	return config, changes
+}
+
+// reconcileProfileDevices replaces the devices an application declares in the
+// catalog with the desired ones and keeps the others. It returns the new
+// devices and the changes, sorted by name.
+func reconcileProfileDevices(current map[string]map[string]string, desired map[string]map[string]string) (map[string]map[string]string, []string) {
+	devices := map[string]map[string]string{}
+	names := map[string]bool{}
+	for name, device := range current {
+		names[name] = true
+		if !strings.HasPrefix(name, catalogDevicePrefix) {
+			devices[name] = device
+		}
+	}
+	for name, device := range desired {
+		names[name] = true
+		devices[name] = device
+	}
+
+	var changes []string
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		old, had := current[name]
+		device, has := devices[name]
+		switch {
+		case has && !had:
+			changes = append(changes, fmt.Sprintf("add device %s (%s)", name, device["source"]))
+		case had && !has:
+			changes = append(changes, fmt.Sprintf("remove device %s", name))
+		case !maps.Equal(old, device):
+			changes = append(changes, fmt.Sprintf("update device %s", name))
+		}
+	}
+	return devices, changes
+}
+
+// ensureAppProfile creates the profile of an application when it does not
+// exist yet and returns its name. An existing profile is used only when it
+// matches the catalog settings for method and hardened: it is shared by other
+// instances, so launching one instance must not change it. Updating it is left
+// to profile sync.
+func (c *cmdGlobal) ensureAppProfile(app Application, method InstallMethods, hardened bool) (string, error) {
+	server, err := c.server()
+	if err != nil {
+		return "", err
+	}
+	name := appProfileName(app.Slug)
+	desired := appProfileConfig(app, method, hardened)
+	devices := securityDevices(app, false)
+	if devices == nil {
+		devices = map[string]map[string]string{}
+	}
+	profile, _, err := server.GetProfile(name)
+	if err != nil {
+		log.Info("Creating application profile", "profile", name)
+		err = server.CreateProfile(api.ProfilesPost{
+			Name: name,
+			ProfilePut: api.ProfilePut{
+				Config:      desired,
+				Description: "scripts-cli settings for " + app.Name,
+				Devices:     devices,
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create profile %s: %w", name, err)
+		}
+		return name, nil
+	}
+	_, changes := reconcileProfileConfig(profile.Config, desired)
+	_, deviceChanges := reconcileProfileDevices(profile.Devices, securityDevices(app, false))
+	changes = append(changes, deviceChanges...)
+	if len(changes) > 0 {
+		return "", fmt.Errorf("profile %s does not match this launch (%s), update it with profile sync or launch without --app-profile",
+			name, strings.Join(changes, ", "))
+	}
+	return name, nil
+}
+
+// shareProfileDevice adds a device, and the configuration it needs, to an
+// application profile so all instances of the application get it. It reports
+// false when the profile has another device by that name, which the instance
+// then keeps its own device over.
+func shareProfileDevice(server incus.InstanceServer, name string, device string, values map[string]string, config map[string]string) (bool, error) {
+	profile, etag, err := server.GetProfile(name)
+	if err != nil {
+		return false, fmt.Errorf("failed to get profile %s: %w", name, err)
+	}
+	if current, ok := profile.Devices[device]; ok {
+		return maps.Equal(current, values), nil
+	}
+	put := profile.Writable()
+	if put.Devices == nil {
+		put.Devices = map[string]map[string]string{}
+	}
+	put.Devices[device] = values
+	if put.Config == nil {
+		put.Config = map[string]string{}
+	}
+	maps.Copy(put.Config, config)
+	log.Info("Sharing device through the application profile", "profile", name, "device", device)
+	err = server.UpdateProfile(name, put, etag)
+	if err != nil {
+		return false, fmt.Errorf("failed to update profile %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// storageProfileName is the profile giving instances a root disk on hosts
+// whose default profile has none, like TrueNAS.
+const storageProfileName = "scriptcli-storage"
+
+// managedProfile reports whether scripts-cli owns a profile.
+func managedProfile(name string) bool {
+	return name == storageProfileName || strings.HasPrefix(name, appProfilePrefix)
+}
+
+// storageProfile is the desired storage profile for a pool.
+func storageProfile(pool string) api.ProfilePut {
+	return api.ProfilePut{
+		Config:      map[string]string{},
+		Description: "Storage profile for scripts-cli",
+		Devices:     map[string]map[string]string{"root": rootDevice(pool, "")},
+	}
+}
+
+// storageProfilePool chooses the pool of the storage profile: the requested
+// one, else the current one when it still exists, else the pool named
+// default or the first pool.
+func storageProfilePool(pools []string, requested string, current string) (string, error) {
+	if len(pools) == 0 {
+		return "", errors.New("no storage pools found")
+	}
+	switch {
+	case requested != "":
+		if !slices.Contains(pools, requested) {
+			return "", fmt.Errorf("storage pool %q does not exist", requested)
+		}
+		return requested, nil
+	case current != "" && slices.Contains(pools, current):
+		return current, nil
+	case slices.Contains(pools, "default"):
+		return "default", nil
+	default:
+		return pools[0], nil
+	}
+}
+
+// repairProfile sets the desired description, configuration and devices of a
+// profile and keeps everything else. It returns the repaired profile and the
+// changes, sorted.
+func repairProfile(current api.ProfilePut, desired api.ProfilePut) (api.ProfilePut, []string) {
+	repaired := api.ProfilePut{
+		Config:      maps.Clone(current.Config),
+		Description: current.Description,
+		Devices:     maps.Clone(current.Devices),
+	}
+	if repaired.Config == nil {
+		repaired.Config = map[string]string{}
+	}
+	if repaired.Devices == nil {
+		repaired.Devices = map[string]map[string]string{}
+	}
+
+	var changes []string
+	if desired.Description != "" && current.Description != desired.Description {
+		repaired.Description = desired.Description
+		changes = append(changes, "set description")
+	}
+	for k, v := range desired.Config {
+		if old, ok := current.Config[k]; !ok || old != v {
+			repaired.Config[k] = v
+			changes = append(changes, fmt.Sprintf("set %s=%s", k, v))
+		}
+	}
+	for name, device := range desired.Devices {
+		old, ok := current.Devices[name]
+		switch {
+		case !ok:
+			changes = append(changes, "add device "+name)
+		case !maps.Equal(old, device):
+			changes = append(changes, "update device "+name)
+		default:
+			continue
+		}
+		repaired.Devices[name] = device
+	}
+	slices.Sort(changes)
+	return repaired, changes
+}
+
+// ensureStorageProfile creates the storage profile, or repairs it in place,
+// and returns its name.
+func (c *cmdGlobal) ensureStorageProfile(pool string) (string, error) {
+	server, err := c.server()
+	if err != nil {
+		return "", err
+	}
+	pools, err := server.GetStoragePoolNames()
+	if err != nil {
+		return "", fmt.Errorf("failed to list storage pools: %w", err)
+	}
+
+	profile, etag, err := server.GetProfile(storageProfileName)
+	if err != nil {
+		pool, err = storageProfilePool(pools, pool, "")
+		if err != nil {
+			return "", err
+		}
+		log.Info("Creating storage profile", "profile", storageProfileName, "pool", pool)
+		err = server.CreateProfile(api.ProfilesPost{Name: storageProfileName, ProfilePut: storageProfile(pool)})
+		if err != nil {
+			return "", fmt.Errorf("failed to create profile %s: %w", storageProfileName, err)
+		}
+		return storageProfileName, nil
+	}
+
+	pool, err = storageProfilePool(pools, pool, profile.Devices["root"]["pool"])
+	if err != nil {
+		return "", err
+	}
+	put, changes := repairProfile(profile.Writable(), storageProfile(pool))
+	if len(changes) == 0 {
+		log.Debug("Storage profile is up to date", "profile", storageProfileName, "pool", pool)
+		return storageProfileName, nil
+	}
+	log.Info("Repairing storage profile", "profile", storageProfileName, "pool", pool, "changes", strings.Join(changes, ", "))
+	err = server.UpdateProfile(storageProfileName, put, etag)
+	if err != nil {
+		return "", fmt.Errorf("failed to repair profile %s: %w", storageProfileName, err)
+	}
+	return storageProfileName, nil
+}
+
+// usedByNames turns the used_by URLs of a profile into instance names.
+func usedByNames(usedBy []string) []string {
+	names := make([]string, 0, len(usedBy))
+	for _, u := range usedBy {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			names = append(names, u)
+			continue
+		}
+		name := path.Base(parsed.Path)
+		if project := parsed.Query().Get("project"); project != "" && project != "default" {
+			name = project + "/" + name
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+func Test_appProfileConfig(t *testing.T) {
+	method := InstallMethods{Type: "default", Resources: Resources{CPU: 2, RAM: 2048, HDD: 8}}
+	tests := []struct {
+		name     string
+		app      Application
+		hardened bool
+		want     map[string]string
+	}{
+		{
+			"legacy security",
+			Application{Slug: "jellyfin", Source: "community"},
+			false,
+			map[string]string{
+				"limits.cpu": "2", "limits.memory": "2048MiB",
+				"security.nesting": "true", "security.syscalls.intercept.mknod": "true", "security.syscalls.intercept.setxattr": "true",
+				"user.scriptcli.app": "jellyfin", "user.scriptcli.source": "community", "user.scriptcli.install-method": "default",
+			},
+		},
+		{
+			"hardened",
+			Application{Slug: "wireguard", Security: &Security{KernelModules: []string{"wireguard"}}},
+			true,
+			map[string]string{
+				"limits.cpu": "2", "limits.memory": "2048MiB", "linux.kernel_modules": "wireguard",
+				"user.scriptcli.app": "wireguard", "user.scriptcli.install-method": "default", "user.scriptcli.hardened": "true",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appProfileConfig(tt.app, method, tt.hardened); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("appProfileConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_reconcileProfileConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     map[string]string
+		desired     map[string]string
+		want        map[string]string
+		wantChanges []string
+	}{
+		{
+			"up to date",
+			map[string]string{"limits.cpu": "2", "user.scriptcli.app": "sonarr"},
+			map[string]string{"limits.cpu": "2", "user.scriptcli.app": "sonarr"},
+			map[string]string{"limits.cpu": "2", "user.scriptcli.app": "sonarr"},
+			nil,
+		},
+		{
+			"catalog changed",
+			map[string]string{"limits.cpu": "1", "security.nesting": "true", "user.scriptcli.app": "sonarr"},
+			map[string]string{"limits.cpu": "2", "limits.memory": "1024MiB", "user.scriptcli.app": "sonarr"},
+			map[string]string{"limits.cpu": "2", "limits.memory": "1024MiB", "user.scriptcli.app": "sonarr"},
+			[]string{"set limits.cpu=2 (was 1)", "set limits.memory=1024MiB", "unset security.nesting (was true)"},
+		},
+		{
+			"other keys kept",
+			map[string]string{"nvidia.runtime": "true", "limits.cpu": "1"},
+			map[string]string{"limits.cpu": "1"},
+			map[string]string{"nvidia.runtime": "true", "limits.cpu": "1"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changes := reconcileProfileConfig(tt.current, tt.desired)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reconcileProfileConfig() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(changes, tt.wantChanges) {
+				t.Errorf("reconcileProfileConfig() changes = %v, want %v", changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func Test_reconcileProfileDevices(t *testing.T) {
+	tun := map[string]string{"type": "unix-char", "source": "/dev/net/tun", "path": "/dev/net/tun"}
+	fuse := map[string]string{"type": "unix-char", "source": "/dev/fuse", "path": "/dev/fuse"}
+	gpu := map[string]string{"type": "gpu", "pci": "0000:01:00.0"}
+	tests := []struct {
+		name        string
+		current     map[string]map[string]string
+		desired     map[string]map[string]string
+		want        map[string]map[string]string
+		wantChanges []string
+	}{
+		{
+			"up to date",
+			map[string]map[string]string{"dev-net-tun": tun, "gpu": gpu},
+			map[string]map[string]string{"dev-net-tun": tun},
+			map[string]map[string]string{"dev-net-tun": tun, "gpu": gpu},
+			nil,
+		},
+		{
+			"catalog changed",
+			map[string]map[string]string{"dev-net-tun": tun, "gpu": gpu},
+			map[string]map[string]string{"dev-fuse": fuse},
+			map[string]map[string]string{"dev-fuse": fuse, "gpu": gpu},
+			[]string{"add device dev-fuse (/dev/fuse)", "remove device dev-net-tun"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changes := reconcileProfileDevices(tt.current, tt.desired)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reconcileProfileDevices() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(changes, tt.wantChanges) {
+				t.Errorf("reconcileProfileDevices() changes = %v, want %v", changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func Test_storageProfilePool(t *testing.T) {
+	tests := []struct {
+		name      string
+		pools     []string
+		requested string
+		current   string
+		want      string
+		wantErr   bool
+	}{
+		{"requested", []string{"default", "tank"}, "tank", "default", "tank", false},
+		{"requested missing", []string{"default"}, "tank", "", "", true},
+		{"current kept", []string{"default", "tank"}, "", "tank", "tank", false},
+		{"current gone", []string{"default", "fast"}, "", "tank", "default", false},
+		{"first pool", []string{"tank", "fast"}, "", "", "tank", false},
+		{"no pools", nil, "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storageProfilePool(tt.pools, tt.requested, tt.current)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("storageProfilePool() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("storageProfilePool() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_repairProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     api.ProfilePut
+		want        api.ProfilePut
+		wantChanges []string
+	}{
+		{
+			"up to date",
+			storageProfile("tank"),
+			storageProfile("tank"),
+			nil,
+		},
+		{
+			"wrong pool",
+			api.ProfilePut{
+				Config:      map[string]string{"limits.cpu": "2"},
+				Description: "Storage profile for scripts-cli",
+				Devices:     map[string]map[string]string{"root": rootDevice("old", ""), "eth0": {"type": "nic", "network": "incusbr0"}},
+			},
+			api.ProfilePut{
+				Config:      map[string]string{"limits.cpu": "2"},
+				Description: "Storage profile for scripts-cli",
+				Devices:     map[string]map[string]string{"root": rootDevice("tank", ""), "eth0": {"type": "nic", "network": "incusbr0"}},
+			},
+			[]string{"update device root"},
+		},
+		{
+			"missing root disk",
+			api.ProfilePut{},
+			storageProfile("tank"),
+			[]string{"add device root", "set description"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changes := repairProfile(tt.current, storageProfile("tank"))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("repairProfile() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(changes, tt.wantChanges) {
+				t.Errorf("repairProfile() changes = %v, want %v", changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func Test_usedByNames(t *testing.T) {
+	got := usedByNames([]string{"/1.0/instances/media", "/1.0/instances/sonarr?project=apps", "/1.0/instances/web?project=default"})
+	want := []string{"media", "apps/sonarr", "web"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("usedByNames() = %v, want %v", got, want)
+	}
+}
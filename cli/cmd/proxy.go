@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/spf13/viper"
+)
+
+// defaultNoProxy keeps local connections away from the proxy when --no-proxy
+// is not given.
+const defaultNoProxy = "localhost,127.0.0.1,::1"
+
+// aptProxyFile configures apt to use the proxy during the install.
+const aptProxyFile = "/etc/apt/apt.conf.d/90scriptcli-proxy"
+
+// validateProxy accepts http and https proxy URLs.
+func validateProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid proxy %q, use a URL like http://proxy.example.com:3128", proxy)
+	}
+	return nil
+}
+
+// proxyEnvironment is the environment that points an instance at a proxy.
+// Both spellings are set, tools disagree on which one they read.
+func proxyEnvironment(proxy string, noProxy string) map[string]string {
+	if proxy == "" {
+		return nil
+	}
+	env := map[string]string{}
+	for _, k := range []string{"http_proxy", "https_proxy"} {
+		env[k] = proxy
+		env[strings.ToUpper(k)] = proxy
+	}
+	if noProxy != "" {
+		env["no_proxy"] = noProxy
+		env["NO_PROXY"] = noProxy
+	}
+	return env
+}
+
+// aptProxyConfig is the content of the apt proxy configuration.
+func aptProxyConfig(proxy string) string {
+	return fmt.Sprintf("Acquire::http::Proxy %q;\nAcquire::https::Proxy %q;\n", proxy, proxy)
+}
+
+// configureAptProxy writes the apt proxy configuration in instances that use apt.
+func configureAptProxy(server incus.InstanceServer, name string, proxy string) error {
+	script := `[ -d /etc/apt/apt.conf.d ] || exit 0
+printf '%s' "$APT_PROXY" >"$APT_PROXY_FILE"`
+	return instanceShell(server, name, script, map[string]string{"APT_PROXY": aptProxyConfig(proxy), "APT_PROXY_FILE": aptProxyFile})
+}
+
+// removeProxy removes the proxy environment and apt configuration from an
+// instance once it is installed.
+func removeProxy(server incus.InstanceServer, name string) error {
+	var keys []string
+	for k := range proxyEnvironment("proxy", "no-proxy") {
+		keys = append(keys, "environment."+k)
+	}
+	err := unsetInstanceConfig(server, name, keys...)
+	if err != nil {
+		return err
+	}
+	return instanceShell(server, name, "rm -f "+aptProxyFile, nil)
+}
+
+// applyProxyFlags sets the proxy of a launch from the flags or the
+// configuration file.
+func (c *cmdLaunch) applyProxyFlags(settings *LaunchSettings) error {
+	settings.Proxy = c.flagProxy
+	if settings.Proxy == "" {
+		settings.Proxy = viper.GetString("proxy")
+	}
+	if settings.Proxy == "" {
+		return nil
+	}
+	err := validateProxy(settings.Proxy)
+	if err != nil {
+		return err
+	}
+	settings.NoProxy = c.flagNoProxy
+	if settings.NoProxy == "" {
+		settings.NoProxy = viper.GetString("no-proxy")
+	}
+	if settings.NoProxy == "" {
+		settings.NoProxy = defaultNoProxy
+	}
+	settings.PersistProxy = c.flagPersistProxy || viper.GetBool("persist-proxy")
+	return nil
+}
@@ -0,0 +1,248 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/spf13/cobra"
+)
+
+// genericizeScript removes the state that has to be unique per instance. It is
+// recreated on first boot, or by launch --from-image.
+const genericizeScript = `rm -f /etc/ssh/ssh_host_*
+if [ -f /etc/machine-id ]; then : > /etc/machine-id; fi
+rm -f /var/lib/dbus/machine-id /install.func
+rm -f /root/.bash_history
+`
+
+type cmdPublish struct {
+	global *cmdGlobal
+
+	flagAlias  string
+	flagPublic bool
+	flagForce  bool
+}
+
+func (c *cmdPublish) Command() *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Use = "publish <instance>"
+	cmd.Short = "publish an installed application as an image"
+	cmd.Args = cobra.ExactArgs(1)
+	cmd.Long =
+		`Publish an installed application as an image
+
+Stops the instance, removes machine specific state (SSH host keys, the machine-id and
+the environment.* keys used by the installer) and publishes it as an incus image. The
+image records the application it was built from as scriptcli.* image properties.
+
+Launch new instances from the image without running the installer again:
+
+  scripts-cli launch immich photos2 --from-image app/immich
+
+The instance gets new SSH host keys afterwards. An instance that was running is started
+again, one that was stopped is left stopped.`
+	cmd.Example = `  scripts-cli publish photos --alias app/immich`
+	cmd.Flags().StringVar(&c.flagAlias, "alias", "", "alias for the published image")
+	cmd.Flags().BoolVar(&c.flagPublic, "public", false, "make the image available to untrusted clients")
+	cmd.Flags().BoolVar(&c.flagForce, "force", false, "move the alias if it already exists")
+	_ = cmd.MarkFlagRequired("alias")
+	cmd.RunE = c.Run
+
+	return cmd
+}
+
+func (c *cmdPublish) Run(cmd *cobra.Command, args []string) (err error) {
+	name := args[0]
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	inst, etag, err := server.GetInstance(name)
+	if err != nil {
+		return fmt.Errorf("failed to get instance %s: %w", name, err)
+	}
+	prov := instanceProvenance(inst.Config)
+	if prov["app"] == "" {
+		log.Warn("Instance was not launched by scripts-cli, the image will not record its application", "instance", name)
+	}
+
+	alias, _, err := server.GetImageAlias(c.flagAlias)
+	if err == nil && !c.flagForce {
+		return fmt.Errorf("image alias %s already exists, use --force to move it", c.flagAlias)
+	}
+
+	wasRunning := inst.StatusCode == api.Running
+	if !wasRunning {
+		log.Info("Starting instance to clean it", "instance", name)
+		err = setInstanceState(server, name, "start")
+		if err != nil {
+			return err
+		}
+	}
+	// the instance gets new SSH host keys, also when publishing fails
+	defer func() {
+		restoreErr := restoreInstance(server, name, wasRunning)
+		if err == nil {
+			err = restoreErr
+		}
+	}()
+	log.Info("Removing machine specific state", "instance", name)
+	err = instanceShell(server, name, genericizeScript, nil)
+	if err != nil {
+		return err
+	}
+	log.Info("Stopping instance", "instance", name)
+	err = setInstanceState(server, name, "stop")
+	if err != nil {
+		return err
+	}
+
+	// the installer settings include the root password
+	inst, etag, err = server.GetInstance(name)
+	if err != nil {
+		return err
+	}
+	put := inst.Writable()
+	maps.DeleteFunc(put.Config, func(k, v string) bool { return strings.HasPrefix(k, "environment.") })
+	op, err := server.UpdateInstance(name, put, etag)
+	if err == nil {
+		err = op.Wait()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove installer settings from %s: %w", name, err)
+	}
+
+	properties := map[string]string{
+		"description":         fmt.Sprintf("%s published from %s", prov["name"], name),
+		"scriptcli.instance":  name,
+		"scriptcli.published": time.Now().UTC().Format(time.RFC3339),
+	}
+	if prov["name"] == "" {
+		properties["description"] = "published from " + name
+	}
+	if prov["os"] != "" {
+		properties["os"] = prov["os"]
+		properties["release"] = prov["version"]
+	}
+	for k, v := range prov {
+		if k != "launched" {
+			properties["scriptcli."+k] = v
+		}
+	}
+
+	log.Info("Publishing image", "instance", name, "alias", c.flagAlias)
+	op, err = server.CreateImage(api.ImagesPost{
+		ImagePut: api.ImagePut{Public: c.flagPublic, Properties: properties},
+		Source:   &api.ImagesPostSource{Type: "instance", Name: name},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to publish %s: %w", name, err)
+	}
+	_, err = op.AddHandler(operationProgress("Publishing " + name))
+	if err != nil {
+		return err
+	}
+	err = op.Wait()
+	if isTerminal(os.Stderr) {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to publish %s: %w", name, err)
+	}
+	fingerprint, _ := op.Get().Metadata["fingerprint"].(string)
+	if fingerprint == "" {
+		return fmt.Errorf("publishing %s did not return an image fingerprint", name)
+	}
+
+	if alias != nil {
+		err = server.DeleteImageAlias(c.flagAlias)
+		if err != nil {
+			return err
+		}
+	}
+	err = server.CreateImageAlias(api.ImageAliasesPost{
+		ImageAliasesEntry: api.ImageAliasesEntry{
+			ImageAliasesEntryPut: api.ImageAliasesEntryPut{Target: fingerprint, Description: properties["description"]},
+			Name:                 c.flagAlias,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create image alias %s: %w", c.flagAlias, err)
+	}
+	log.Info("Published image", "alias", c.flagAlias, "fingerprint", fingerprint[:12])
+	return nil
+}
+
+// restoreInstance gives a published instance new SSH host keys, so sshd
+// starts again, and stops it again unless it was running before.
+func restoreInstance(server incus.InstanceServer, name string, wasRunning bool) error {
+	inst, _, err := server.GetInstance(name)
+	if err != nil {
+		return fmt.Errorf("failed to get instance %s: %w", name, err)
+	}
+	if inst.StatusCode != api.Running {
+		log.Info("Starting instance", "instance", name)
+		err = setInstanceState(server, name, "start")
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("Regenerating SSH host keys", "instance", name)
+	err = regenerateHostKeys(server, name)
+	if err != nil {
+		return err
+	}
+	if wasRunning {
+		return nil
+	}
+	log.Info("Stopping instance", "instance", name)
+	return setInstanceState(server, name, "stop")
+}
+
+// checkPublishedImage confirms that an image was published from an instance
+// of the application with the given slug.
+func (c *cmdGlobal) checkPublishedImage(ref imageRef, slug string) error {
+	imageServer, err := c.conf.GetImageServer(ref.Remote)
+	if err != nil {
+		return fmt.Errorf("failed to connect to image remote %q: %w", ref.Remote, err)
+	}
+	image, _, err := imageServer.GetImage(ref.Fingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to get image %s: %w", ref, err)
+	}
+	app := image.Properties["scriptcli.app"]
+	if app == "" {
+		return fmt.Errorf("image %s was not created with scripts-cli publish", ref)
+	}
+	if app != slug {
+		return fmt.Errorf("image %s contains %s, not %s", ref, app, slug)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// readinessHost is resolved to confirm DNS works. The install scripts
+// download most of their sources from GitHub.
+const readinessHost = "github.com"
+
+// defaultRouteScript succeeds when the instance has an IPv4 or IPv6 default route.
+const defaultRouteScript = `ip route show default 2>/dev/null | grep -q . && exit 0
+ip -6 route show default 2>/dev/null | grep -q . && exit 0
+awk '$2 == "00000000" { found = 1 } END { exit !found }' /proc/net/route`
+
+// dnsScript succeeds when the host name in $1 resolves.
+const dnsScript = `getent hosts "$1" >/dev/null 2>&1 && exit 0
+nslookup "$1" >/dev/null 2>&1`
+
+// readinessCheck is one condition an instance has to meet before the
+// installer runs. check returns a description of what is missing.
+type readinessCheck struct {
+	name  string
+	check func() (string, error)
+}
+
+// waitReady waits until an instance can run the installer: commands can be
+// executed, the NIC has an address, there is a default route and DNS
+// resolves, unless dns is false. Each check is retried until timeout runs out.
+func waitReady(server incus.InstanceServer, name string, timeout time.Duration, dns bool) error {
+	deadline := time.Now().Add(timeout)
+	checks := []readinessCheck{
+		{"agent", func() (string, error) {
+			ret, err := instanceExec(server, name, []string{"true"}, nil, nil, nil)
+			if err != nil || ret != 0 {
+				return "commands cannot be run in the instance yet", nil
+			}
+			return "", nil
+		}},
+		{"address", func() (string, error) {
+			return checkAddress(server, name)
+		}},
+		{"route", func() (string, error) {
+			ret, err := instanceExec(server, name, []string{"/bin/sh", "-c", defaultRouteScript}, nil, nil, nil)
+			if err != nil || ret != 0 {
+				return "there is no default route", nil
+			}
+			return "", nil
+		}},
+		{"dns", func() (string, error) {
+			ret, err := instanceExec(server, name, []string{"/bin/sh", "-c", dnsScript, "sh", readinessHost}, nil, nil, nil)
+			if err != nil || ret != 0 {
+				return fmt.Sprintf("%s does not resolve, check the DNS server of the network", readinessHost), nil
+			}
+			return "", nil
+		}},
+	}
+	if !dns {
+		checks = checks[:len(checks)-1]
+	}
+
+	for _, c := range checks {
+		log.Debug("Waiting for instance", "instance", name, "check", c.name)
+		for {
+			problem, err := c.check()
+			if err != nil {
+				return err
+			}
+			if problem == "" {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("instance %s is not ready after %s: %s", name, timeout, problem)
+			}
+			time.Sleep(time.Second)
+		}
+	}
+	log.Info("Instance is ready", "instance", name)
+	return nil
+}
+
+// checkAddress confirms the NIC of the instance has a global address.
+func checkAddress(server incus.InstanceServer, name string) (string, error) {
+	inst, _, err := server.GetInstance(name)
+	if err != nil {
+		return "", err
+	}
+	device, hwaddr := instanceNIC(inst)
+	if device == "" {
+		return "", errors.New("the instance has no network interface")
+	}
+	state, _, err := server.GetInstanceState(name)
+	if err != nil {
+		return "", err
+	}
+	// without a MAC address yet, look for the interface by name
+	ifname := inst.ExpandedDevices[device]["name"]
+	if ifname == "" {
+		ifname = device
+	}
+	iface, addrs := nicAddresses(state, hwaddr, ifname)
+	if iface == "" {
+		return fmt.Sprintf("network interface %s is not up", device), nil
+	}
+	if len(addrs) == 0 {
+		return fmt.Sprintf("no IPv4 or IPv6 address on %s, check that DHCP works on network %s", iface, inst.ExpandedDevices[device]["network"]), nil
+	}
+	log.Debug("Instance has an address", "instance", name, "interface", iface, "addresses", strings.Join(addrs, ", "))
+	return "", nil
+}
+
+// instanceNIC returns the first NIC device of an instance and its MAC address.
+func instanceNIC(inst *api.Instance) (string, string) {
+	var names []string
+	for k, d := range inst.ExpandedDevices {
+		if d["type"] == "nic" {
+			names = append(names, k)
+		}
+	}
+	if len(names) == 0 {
+		return "", ""
+	}
+	sort.Strings(names)
+	device := names[0]
+	hwaddr := inst.ExpandedDevices[device]["hwaddr"]
+	if hwaddr == "" {
+		hwaddr = inst.ExpandedConfig["volatile."+device+".hwaddr"]
+	}
+	return device, hwaddr
+}
+
+// nicAddresses finds the interface with the given MAC address, or the one
+// named ifname when the MAC address is not known, in the instance state and
+// returns its name and global addresses.
+func nicAddresses(state *api.InstanceState, hwaddr string, ifname string) (string, []string) {
+	for name, n := range state.Network {
+		if hwaddr == "" && name != ifname {
+			continue
+		}
+		if hwaddr != "" && !strings.EqualFold(n.Hwaddr, hwaddr) {
+			continue
+		}
+		var addrs []string
+		for _, a := range n.Addresses {
+			if (a.Family == "inet" || a.Family == "inet6") && a.Scope == "global" {
+				addrs = append(addrs, a.Address)
+			}
+		}
+		return name, addrs
+	}
+	return "", nil
+}
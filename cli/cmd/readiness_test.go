@@ -0,0 +1,63 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+func Test_instanceNIC(t *testing.T) {
+	tests := []struct {
+		name       string
+		devices    map[string]map[string]string
+		config     map[string]string
+		wantDevice string
+		wantHwaddr string
+	}{
+		{"none", map[string]map[string]string{"root": {"type": "disk"}}, nil, "", ""},
+		{"volatile", map[string]map[string]string{"eth0": {"type": "nic"}}, map[string]string{"volatile.eth0.hwaddr": "10:66:6a:00:00:01"}, "eth0", "10:66:6a:00:00:01"},
+		{"explicit", map[string]map[string]string{"eth1": {"type": "nic", "hwaddr": "10:66:6a:00:00:02"}, "eth0": {"type": "nic"}}, nil, "eth0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &api.Instance{ExpandedDevices: tt.devices, ExpandedConfig: tt.config}
+			device, hwaddr := instanceNIC(inst)
+			if device != tt.wantDevice || hwaddr != tt.wantHwaddr {
+				t.Errorf("instanceNIC() = %v, %v, want %v, %v", device, hwaddr, tt.wantDevice, tt.wantHwaddr)
+			}
+		})
+	}
+}
+
+func Test_nicAddresses(t *testing.T) {
+	state := &api.InstanceState{Network: map[string]api.InstanceStateNetwork{
+		"lo": {Hwaddr: "", Addresses: []api.InstanceStateNetworkAddress{{Family: "inet", Address: "127.0.0.1", Scope: "local"}}},
+		"enp5s0": {Hwaddr: "10:66:6a:00:00:01", Addresses: []api.InstanceStateNetworkAddress{
+			{Family: "inet", Address: "10.0.0.5", Scope: "global"},
+			{Family: "inet6", Address: "fe80::1", Scope: "link"},
+		}},
+		"eth1": {Hwaddr: "10:66:6a:00:00:02", Addresses: []api.InstanceStateNetworkAddress{{Family: "inet6", Address: "fe80::2", Scope: "link"}}},
+	}}
+	tests := []struct {
+		name      string
+		hwaddr    string
+		ifname    string
+		wantIface string
+		wantAddrs []string
+	}{
+		{"global", "10:66:6A:00:00:01", "eth0", "enp5s0", []string{"10.0.0.5"}},
+		{"linkLocalOnly", "10:66:6a:00:00:02", "eth0", "eth1", nil},
+		{"missing", "10:66:6a:00:00:03", "eth0", "", nil},
+		{"byName", "", "enp5s0", "enp5s0", []string{"10.0.0.5"}},
+		{"notLoopback", "", "eth0", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iface, addrs := nicAddresses(state, tt.hwaddr, tt.ifname)
+			if iface != tt.wantIface || !slices.Equal(addrs, tt.wantAddrs) {
+				t.Errorf("nicAddresses() = %v, %v, want %v, %v", iface, addrs, tt.wantIface, tt.wantAddrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// applicationSchema is the published JSON Schema for json/*.json.
+//
+//go:embed schema/application.schema.json
+var applicationSchema []byte
+
+// jsonSchema is the subset of JSON Schema used by the application schema.
+type jsonSchema struct {
+	Type                 schemaType             `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []any                  `json:"enum"`
+	Pattern              string                 `json:"pattern"`
+	Format               string                 `json:"format"`
+	MinLength            int                    `json:"minLength"`
+	MinItems             int                    `json:"minItems"`
+}
+
+// schemaType is either a single type name or a list of them.
+type schemaType []string
+
+func (t *schemaType) UnmarshalJSON(b []byte) error {
+	var one string
+	if json.Unmarshal(b, &one) == nil {
+		*t = schemaType{one}
+		return nil
+	}
+	var many []string
+	err := json.Unmarshal(b, &many)
+	*t = many
+	return err
+}
+
+func loadSchema(b []byte) (*jsonSchema, error) {
+	var s jsonSchema
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &s, nil
+}
+
+// validate checks a decoded JSON document against the schema and returns
+// one message per violation, prefixed with the path of the offending value.
+func (s *jsonSchema) validate(path string, v any) []string {
+	var errs []string
+	fail := func(format string, args ...any) {
+		errs = append(errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return hasSchemaType(v, t) }) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonTypeName(v))
+		return errs
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+		fail("must be one of %v", s.Enum)
+	}
+
+	switch v := v.(type) {
+	case string:
+		if len(v) < s.MinLength {
+			fail("must not be empty")
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				fail("invalid schema pattern %q", s.Pattern)
+			} else if !re.MatchString(v) {
+				fail("must match %s", s.Pattern)
+			}
+		}
+		if s.Format == "uri" {
+			u, err := url.Parse(v)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				fail("must be an absolute URL")
+			}
+		}
+	case []any:
+		if len(v) < s.MinItems {
+			fail("must have at least %d items", s.MinItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case map[string]any:
+		for _, r := range s.Required {
+			if _, ok := v[r]; !ok {
+				fail("missing required property %q", r)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prop, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					fail("unknown property %q", k)
+				}
+				continue
+			}
+			errs = append(errs, prop.validate(path+"."+k, v[k])...)
+		}
+	}
+	return errs
+}
+
+func hasSchemaType(v any, t string) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return jsonTypeName(v) == t
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -22,7 +22,6 @@ THE SOFTWARE.
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -56,25 +55,9 @@ func (c *cmdSearch) Run(cmd *cobra.Command, args []string) error {
 
 	for _, v := range catalog {
 		if strings.Contains(strings.ToLower(v.Name), strings.ToLower(needle)) {
-			fmt.Printf("%s | %s | \n\t%s\n", v.Slug, v.Name, v.Description)
+			fmt.Printf("%s | %s | %s\n\t%s\n", v.Slug, v.Name, v.Source, v.Description)
 		}
 	}
 
 	return nil
 }
-
-func getContainerCatalog() (map[string]Application, error) {
-	log.Debug("Downloading container catalog")
-	appJson, err := downloadRaw(repository, "json", "ct-index.json")
-	if err != nil {
-		log.Error("Failed to download container catalog:", "error", err)
-		return nil, err
-	}
-	var catalog map[string]Application
-	err = json.Unmarshal(appJson, &catalog)
-	if err != nil {
-		log.Error("Failed to unmarshal container catalog:", "error", err)
-		return nil, err
-	}
-	return catalog, nil
-}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+)
+
+// legacySecurity is what every container got before applications declared
+// their needs. It stays the default so existing installers keep working,
+// --hardened applies only what the application declares.
+var legacySecurity = Security{Nesting: true, SyscallIntercepts: []string{"mknod", "setxattr"}}
+
+// securityConfig derives the security configuration of a container from the
+// catalog. VMs are isolated by the hypervisor and get none.
+func securityConfig(app Application, vm bool, hardened bool) map[string]string {
+	if vm {
+		return nil
+	}
+	var declared Security
+	if app.Security != nil {
+		declared = *app.Security
+		declared.SyscallIntercepts = slices.Clone(declared.SyscallIntercepts)
+	}
+	if !hardened {
+		declared.Nesting = declared.Nesting || legacySecurity.Nesting
+		for _, s := range legacySecurity.SyscallIntercepts {
+			if !slices.Contains(declared.SyscallIntercepts, s) {
+				declared.SyscallIntercepts = append(declared.SyscallIntercepts, s)
+			}
+		}
+	}
+
+	config := map[string]string{}
+	if declared.Nesting {
+		config["security.nesting"] = "true"
+	}
+	for _, s := range declared.SyscallIntercepts {
+		config["security.syscalls.intercept."+s] = "true"
+	}
+	if len(declared.KernelModules) > 0 {
+		config["linux.kernel_modules"] = strings.Join(declared.KernelModules, ",")
+	}
+	if app.Privileged {
+		config["security.privileged"] = "true"
+	}
+	return config
+}
+
+// catalogDevicePrefix starts the names of the devices an application declares
+// in the catalog.
+const catalogDevicePrefix = "dev-"
+
+// securityDevices passes the device nodes an application declares through to
+// a container, named after their path.
+func securityDevices(app Application, vm bool) map[string]map[string]string {
+	if vm || app.Security == nil || len(app.Security.Devices) == 0 {
+		return nil
+	}
+	devices := map[string]map[string]string{}
+	for _, path := range app.Security.Devices {
+		name := catalogDevicePrefix + strings.ReplaceAll(strings.TrimPrefix(path, "/dev/"), "/", "-")
+		devices[name] = map[string]string{"type": "unix-char", "source": path, "path": path}
+	}
+	return devices
+}
+
+// privilegedForm asks for confirmation before creating a privileged
+// container, in which root is root on the host.
+func privilegedForm(app Application, accessible bool) (bool, error) {
+	var confirm bool
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewNote().
+				Title("Privileged Container").
+				Description(fmt.Sprintf("%s runs in a *privileged* container. Root in the container is root on the host, "+
+					"so a compromised application can take over the host.", app.Name)),
+			huh.NewConfirm().
+				Title("Create a privileged container?").
+				Value(&confirm).
+				Affirmative("Yes").
+				Negative("No"),
+		),
+	).WithAccessible(accessible)
+	err := form.Run()
+	if err != nil {
+		return false, err
+	}
+	return confirm, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_securityConfig(t *testing.T) {
+	docker := Application{Security: &Security{Nesting: true, SyscallIntercepts: []string{"mknod", "setxattr"}, KernelModules: []string{"overlay"}}}
+	wireguard := Application{Security: &Security{KernelModules: []string{"wireguard"}}}
+	tests := []struct {
+		name     string
+		app      Application
+		vm       bool
+		hardened bool
+		want     map[string]string
+	}{
+		{"default", Application{}, false, false, map[string]string{
+			"security.nesting":                     "true",
+			"security.syscalls.intercept.mknod":    "true",
+			"security.syscalls.intercept.setxattr": "true",
+		}},
+		{"hardened", Application{}, false, true, map[string]string{}},
+		{"hardened declared", docker, false, true, map[string]string{
+			"security.nesting":                     "true",
+			"security.syscalls.intercept.mknod":    "true",
+			"security.syscalls.intercept.setxattr": "true",
+			"linux.kernel_modules":                 "overlay",
+		}},
+		{"default with modules", wireguard, false, false, map[string]string{
+			"security.nesting":                     "true",
+			"security.syscalls.intercept.mknod":    "true",
+			"security.syscalls.intercept.setxattr": "true",
+			"linux.kernel_modules":                 "wireguard",
+		}},
+		{"privileged", Application{Privileged: true}, false, true, map[string]string{"security.privileged": "true"}},
+		{"vm", docker, true, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := securityConfig(tt.app, tt.vm, tt.hardened); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("securityConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_securityDevices(t *testing.T) {
+	zerotier := Application{Security: &Security{Devices: []string{"/dev/net/tun"}}}
+	tests := []struct {
+		name string
+		app  Application
+		vm   bool
+		want map[string]map[string]string
+	}{
+		{"none", Application{}, false, nil},
+		{"tun", zerotier, false, map[string]map[string]string{
+			"dev-net-tun": {"type": "unix-char", "source": "/dev/net/tun", "path": "/dev/net/tun"},
+		}},
+		{"vm", zerotier, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := securityDevices(tt.app, tt.vm); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("securityDevices() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+)
+
+// rootPool returns the storage pool of the root disk in the given profiles.
+func rootPool(server incus.InstanceServer, profiles []string) (string, error) {
+	pool := ""
+	for _, name := range profiles {
+		profile, _, err := server.GetProfile(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to get profile %s: %w", name, err)
+		}
+		for _, d := range profile.Devices {
+			if d["type"] == "disk" && d["path"] == "/" {
+				pool = d["pool"]
+			}
+		}
+	}
+	if pool == "" {
+		return "", errors.New("none of the profiles has a root disk")
+	}
+	return pool, nil
+}
+
+// quotaDrivers are the storage drivers that can limit the size of a
+// container root disk.
+var quotaDrivers = []string{"zfs", "btrfs", "lvm", "lvmcluster", "ceph"}
+
+// supportsQuota reports whether a storage pool can limit container root disk
+// sizes, and warns when it cannot.
+func (c *cmdGlobal) supportsQuota(name string) (bool, error) {
+	server, err := c.server()
+	if err != nil {
+		return false, err
+	}
+	pool, _, err := server.GetStoragePool(name)
+	if err != nil {
+		return false, fmt.Errorf("failed to get storage pool %s: %w", name, err)
+	}
+	if !slices.Contains(quotaDrivers, pool.Driver) {
+		log.Warn("Storage pool cannot limit container disk sizes, the root disk size is not applied", "pool", name, "driver", pool.Driver)
+		return false, nil
+	}
+	return true, nil
+}
+
+// storagePool is a storage pool with its free space, for the pool picker.
+type storagePool struct {
+	Name   string
+	Driver string
+	// Free is the free space in bytes, -1 when unknown.
+	Free int64
+}
+
+func (p storagePool) String() string {
+	if p.Free < 0 {
+		return fmt.Sprintf("%s (%s)", p.Name, p.Driver)
+	}
+	return fmt.Sprintf("%s (%s, %s free)", p.Name, p.Driver, formatBytes(p.Free))
+}
+
+// storagePools lists the storage pools of the server with their free space.
+func (c *cmdGlobal) storagePools() ([]storagePool, error) {
+	server, err := c.server()
+	if err != nil {
+		return nil, err
+	}
+	pools, err := c.client.StorageList(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage pools: %w", err)
+	}
+	var list []storagePool
+	for _, p := range pools {
+		sp := storagePool{Name: p.Name, Driver: p.Driver, Free: -1}
+		res, err := server.GetStoragePoolResources(p.Name)
+		if err != nil {
+			log.Debug("Failed to get storage pool resources", "pool", p.Name, "error", err)
+		} else if res.Space.Total >= res.Space.Used {
+			sp.Free = int64(res.Space.Total - res.Space.Used)
+		}
+		list = append(list, sp)
+	}
+	slices.SortFunc(list, func(a, b storagePool) int { return strings.Compare(a.Name, b.Name) })
+	return list, nil
+}
+
+// checkStoragePool confirms that a storage pool exists.
+func (c *cmdGlobal) checkStoragePool(name string) error {
+	server, err := c.server()
+	if err != nil {
+		return err
+	}
+	_, _, err = server.GetStoragePool(name)
+	if err != nil {
+		return fmt.Errorf("storage pool %q does not exist", name)
+	}
+	return nil
+}
+
+// rootDevice is the root disk of an instance on the given pool.
+func rootDevice(pool string, size string) map[string]string {
+	device := map[string]string{"type": "disk", "path": "/", "pool": pool}
+	if size != "" {
+		device["size"] = size
+	}
+	return device
+}
+
+// instancePool returns the storage pool the root disk of an instance is
+// created on: the chosen pool, or the pool of the root disk in its profiles.
+func (c *cmdGlobal) instancePool(settings LaunchSettings) (string, error) {
+	if settings.StoragePool != "" {
+		return settings.StoragePool, nil
+	}
+	server, err := c.server()
+	if err != nil {
+		return "", err
+	}
+	profiles := settings.Profiles
+	if len(profiles) == 0 {
+		profiles = []string{"default"}
+	}
+	return rootPool(server, profiles)
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func Test_storagePoolString(t *testing.T) {
+	tests := []struct {
+		name string
+		pool storagePool
+		want string
+	}{
+		{"free", storagePool{Name: "default", Driver: "zfs", Free: 3 << 30}, "default (zfs, 3.0GiB free)"},
+		{"unknown", storagePool{Name: "remote", Driver: "ceph", Free: -1}, "remote (ceph)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pool.String(); got != tt.want {
+				t.Errorf("storagePool.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
 
 type Metadata struct {
-	Categories []struct {
-		Name        string  `json:"name"`
-		ID          int     `json:"id"`
-		SortOrder   float64 `json:"sort_order"`
-		Description string  `json:"description"`
-	} `json:"categories"`
+	Categories []Category `json:"categories"`
+}
+
+type Category struct {
+	Name        string  `json:"name"`
+	ID          int     `json:"id"`
+	SortOrder   float64 `json:"sort_order"`
+	Description string  `json:"description"`
 }
 
 type Application struct {
@@ -19,7 +26,9 @@ type Application struct {
 	Type               string             `json:"type,omitempty"`
 	Updateable         bool               `json:"updateable,omitempty"`
 	Privileged         bool               `json:"privileged,omitempty"`
+	Security           *Security          `json:"security,omitempty"`
 	InterfacePort      int                `json:"interface_port,omitempty"`
+	DataDir            string             `json:"data_dir,omitempty"`
 	Documentation      string             `json:"documentation,omitempty"`
 	Website            string             `json:"website,omitempty"`
 	Logo               string             `json:"logo,omitempty"`
@@ -27,6 +36,9 @@ type Application struct {
 	InstallMethods     []InstallMethods   `json:"install_methods,omitempty"`
 	DefaultCredentials DefaultCredentials `json:"default_credentials,omitempty"`
 	Notes              []Notes            `json:"notes,omitempty"`
+
+	// Source is the name of the catalog source the application was loaded from.
+	Source string `json:"-"`
 }
 
 func (a Application) GetJSON() string {
@@ -36,6 +48,12 @@ func (a Application) GetSlug() string {
 	return strings.ToLower(a.Slug)
 }
 
+// Installer is the path of the container installer in the catalog, which is
+// named after the lowercase slug.
+func (a Application) Installer() string {
+	return "install/" + a.GetSlug() + "-install.sh"
+}
+
 type Resources struct {
 	CPU     int    `json:"cpu,omitempty"`
 	RAM     int    `json:"ram,omitempty"`
@@ -45,38 +63,66 @@ type Resources struct {
 }
 
 func (r Resources) GetOS() string {
-	if r.OS == "" {
-		return "debian"
-	}
-	return strings.ToLower(r.OS)
+	osName, _ := normalizeOS(r.OS, r.Version)
+	return osName
 }
 func (r Resources) GetVersion() string {
-	if r.Version == "" {
-		return "12"
-	}
-	return strings.ToLower(r.Version)
+	_, version := normalizeOS(r.OS, r.Version)
+	return version
 }
 func (r Resources) Image() string {
-	var os string
-	var version string
-	if r.OS == "" {
-		os = "debian"
-	} else {
-		os = strings.ToLower(r.OS)
-	}
-	if r.Version == "" {
-		version = "12"
-	} else {
-		version = strings.ToLower(r.Version)
-	}
-	return strings.ToLower(os) + "/" + strings.ToLower(version)
+	osName, version := normalizeOS(r.OS, r.Version)
+	return osName + "/" + version
 }
 
 type InstallMethods struct {
 	Type      string    `json:"type,omitempty"`
 	Script    string    `json:"script,omitempty"`
 	Resources Resources `json:"resources,omitempty"`
+	VM        *VMSpec   `json:"vm,omitempty"`
+}
+
+// Label describes the install method for pickers.
+func (m InstallMethods) Label() string {
+	osName, version := normalizeOS(m.Resources.OS, m.Resources.Version)
+	return fmt.Sprintf("%s: %s %s, %d CPU, %d MiB RAM, %d GiB disk", m.Type, osName, version, m.Resources.CPU, m.Resources.RAM, m.Resources.HDD)
 }
+
+// VMSpec describes how launch creates a "vm" type application.
+type VMSpec struct {
+	// Mode is cloud-init for distribution images, disk-image for vendor disk
+	// images and iso for installer ISOs.
+	Mode string `json:"mode"`
+	// Architectures the vendor image is published for, all when empty.
+	Architectures []string `json:"architectures,omitempty"`
+	// SecureBoot is disabled for images that are not signed.
+	SecureBoot *bool `json:"secure_boot,omitempty"`
+	// Packages and Commands are installed and run by cloud-init.
+	Packages []string   `json:"packages,omitempty"`
+	Commands []string   `json:"commands,omitempty"`
+	Image    *DiskImage `json:"image,omitempty"`
+}
+
+// DiskImage is a vendor disk image or ISO. The URL may contain a {version}
+// placeholder, filled in from the string at VersionKey in the JSON document
+// at VersionURL.
+type DiskImage struct {
+	URL         string `json:"url"`
+	Format      string `json:"format"`
+	Compression string `json:"compression,omitempty"`
+	VersionURL  string `json:"version_url,omitempty"`
+	VersionKey  string `json:"version_key,omitempty"`
+}
+
+// Security lists the container features an application needs.
+type Security struct {
+	Nesting           bool     `json:"nesting,omitempty"`
+	SyscallIntercepts []string `json:"syscall_intercepts,omitempty"`
+	KernelModules     []string `json:"kernel_modules,omitempty"`
+	// Devices are host character devices the application needs, like /dev/net/tun.
+	Devices []string `json:"devices,omitempty"`
+}
+
 type DefaultCredentials struct {
 	Username any `json:"username,omitempty"`
 	Password any `json:"password,omitempty"`
@@ -96,11 +142,30 @@ type LaunchSettings struct {
 	Name             string            `json:"name,omitempty"`
 	Image            string            `json:"image,omitempty"`
 	Network          string            `json:"network,omitempty"`
+	NICType          string            `json:"nic_type,omitempty"`
+	IPv4Address      string            `json:"ipv4_address,omitempty"`
+	IPv6Address      string            `json:"ipv6_address,omitempty"`
+	VLAN             int               `json:"vlan,omitempty"`
+	IPv6             bool              `json:"ipv6,omitempty"`
+	Timezone         string            `json:"timezone,omitempty"`
+	Locale           string            `json:"locale,omitempty"`
+	AptCacher        string            `json:"apt_cacher,omitempty"`
+	Proxy            string            `json:"proxy,omitempty"`
+	NoProxy          string            `json:"no_proxy,omitempty"`
+	PersistProxy     bool              `json:"persist_proxy,omitempty"`
+	GPU              string            `json:"gpu,omitempty"`
+	GPUMdev          string            `json:"gpu_mdev,omitempty"`
+	Devices          []hostDevice      `json:"devices,omitempty"`
+	Mounts           []hostMount       `json:"mounts,omitempty"`
+	Volumes          []volumeMount     `json:"volumes,omitempty"`
+	DataVolume       bool              `json:"data_volume,omitempty"`
+	Privileged       bool              `json:"privileged,omitempty"`
 	Profiles         []string          `json:"profiles,omitempty"`
+	StoragePool      string            `json:"storage_pool,omitempty"`
 	CPU              int               `json:"cpu,omitempty"`
 	RAM              string            `json:"ram,omitempty"`
 	VM               bool              `json:"vm,omitempty"`
-	VMRootDiskSize   string            `json:"vm_root_disk_size,omitempty"`
+	RootDiskSize     string            `json:"root_disk_size,omitempty"`
 	VMSecureBoot     bool              `json:"vm_secure_boot,omitempty"`
 	RootPassword     string            `json:"root_password,omitempty"`
 	EnableSSH        bool              `json:"enable_ssh,omitempty"`
@@ -116,6 +181,22 @@ func NewLaunchSettings(a Application, name string) LaunchSettings {
 		Profiles:      []string{"default"},
 		InstallMethod: 0,
 	}
-	l.Image = "images:" + a.InstallMethods[0].Resources.Image()
+	l.Image = viper.GetString("image-remote") + ":" + a.InstallMethods[0].Resources.Image()
+	l.ApplyResources(a.InstallMethods[0].Resources)
 	return l
 }
+
+// ApplyResources uses the resources of an install method as the defaults for
+// the instance size.
+func (l *LaunchSettings) ApplyResources(r Resources) {
+	l.CPU, l.RAM, l.RootDiskSize = 0, "", ""
+	if r.CPU > 0 {
+		l.CPU = r.CPU
+	}
+	if r.RAM > 0 {
+		l.RAM = fmt.Sprintf("%dMiB", r.RAM)
+	}
+	if r.HDD > 0 {
+		l.RootDiskSize = fmt.Sprintf("%dGiB", r.HDD)
+	}
+}
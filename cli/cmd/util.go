@@ -2,9 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -26,20 +25,19 @@ func rawURL(repo string, paths ...string) string {
 }
 
 func downloadRaw(repo string, paths ...string) ([]byte, error) {
-	resp, err := http.Get(rawURL(repo, paths...))
+	f, err := defaultFetcher()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return f.fetch(context.Background(), rawURL(repo, paths...))
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download file: %s", resp.Status)
-	}
-	bb, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// validateOptionalSize accepts an empty size, meaning no limit.
+func validateOptionalSize(size string) error {
+	if size == "" {
+		return nil
 	}
-	return bb, nil
+	return validateDiskSize(size)
 }
 
 func validateDiskSize(size string) error {
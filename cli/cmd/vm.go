@@ -0,0 +1,545 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	vmModeCloudInit = "cloud-init"
+	vmModeDiskImage = "disk-image"
+	vmModeISO       = "iso"
+)
+
+// vmImagePrefix is the alias prefix of vendor disk images imported by launch.
+const vmImagePrefix = "scriptcli/"
+
+// launchVM creates a "vm" type application from its declarative vm metadata.
+func (c *cmdLaunch) launchVM(application Application, settings LaunchSettings, accessible bool) error {
+	method := application.InstallMethods[settings.InstallMethod]
+	spec := method.VM
+	if spec == nil {
+		return fmt.Errorf("%s has no vm metadata in the catalog and can only be installed with %s", application.Name, method.Script)
+	}
+
+	server, err := c.global.server()
+	if err != nil {
+		return err
+	}
+	arch, err := c.global.serverArchitecture()
+	if err != nil {
+		return err
+	}
+	if len(spec.Architectures) > 0 && !slices.Contains(spec.Architectures, arch) {
+		return fmt.Errorf("%s is only available for %s, not %s", application.Name, strings.Join(spec.Architectures, ", "), arch)
+	}
+
+	proceed, err := launchForm(application.Name, application.Description, accessible)
+	if err != nil {
+		return err
+	}
+	if !proceed {
+		log.Error("Instance creation cancelled")
+		return nil
+	}
+	if spec.Mode == vmModeCloudInit {
+		err = sshForm(&settings, accessible)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Info("The VM sets up its own accounts and SSH on first boot, see its documentation", "application", application.Name)
+	}
+
+	pool, err := c.global.instancePool(settings)
+	if err != nil {
+		return err
+	}
+	settings.StoragePool = pool
+	err = c.global.preflight(settings, c.flagForce)
+	if err != nil {
+		return err
+	}
+	devices, err := c.global.vmDevices(server, settings, pool, application.DataDir)
+	if err != nil {
+		return err
+	}
+	err = ensureVolumes(server, settings, pool)
+	if err != nil {
+		return err
+	}
+
+	req := api.InstancesPost{
+		Name: settings.Name,
+		Type: api.InstanceTypeVM,
+		InstancePut: api.InstancePut{
+			Profiles: settings.Profiles,
+			Config:   vmConfig(settings, spec),
+			Devices:  map[string]map[string]string{},
+		},
+	}
+	maps.Copy(req.Devices, devices)
+	req.Devices["root"] = rootDevice(pool, settings.RootDiskSize)
+	nicName, nic, err := c.global.networkDevice(settings)
+	if err != nil {
+		return err
+	}
+	if nic != nil {
+		req.Devices[nicName] = nic
+	}
+
+	var image imageRef
+	switch spec.Mode {
+	case vmModeCloudInit:
+		image, err = c.global.selectImage(application, method.Resources, true, c.flagImage)
+		if err != nil {
+			return err
+		}
+		image = c.global.cloudVariant(image)
+		cached, err := c.global.cacheImage(server, image, true, true)
+		if err != nil {
+			return err
+		}
+		req.Source = api.InstanceSource{Type: "image", Fingerprint: cached.Fingerprint}
+		ci := cloudInit{Timezone: settings.Timezone, Locale: settings.Locale, Packages: spec.Packages, Commands: spec.Commands}
+		if settings.EnableSSH {
+			ci.applySSH(settings)
+		}
+		if settings.AptCacher != "" {
+			ci.WriteFiles = append(ci.WriteFiles, aptCacherFiles(settings.AptCacher)...)
+		} else if settings.Proxy != "" {
+			// removed again after cloud-init unless --persist-proxy
+			ci.WriteFiles = append(ci.WriteFiles, cloudFile{Path: aptProxyFile, Content: aptProxyConfig(settings.Proxy), Permissions: "0644"})
+		}
+		userData, err := ci.userData()
+		if err != nil {
+			return err
+		}
+		req.Config["cloud-init.user-data"] = userData
+	case vmModeDiskImage:
+		image, err = importDiskImage(server, application, spec.Image, arch)
+		if err != nil {
+			return err
+		}
+		image.Remote = c.global.conf.DefaultRemote
+		req.Source = api.InstanceSource{Type: "image", Alias: image.Name}
+	case vmModeISO:
+		volume, err := importISO(server, pool, application, spec.Image)
+		if err != nil {
+			return err
+		}
+		image = imageRef{Remote: c.global.conf.DefaultRemote, Name: volume}
+		req.Source = api.InstanceSource{Type: "none"}
+		req.Devices["iso"] = map[string]string{"type": "disk", "pool": pool, "source": volume, "boot.priority": "10"}
+	default:
+		return fmt.Errorf("%s uses unknown vm mode %q", application.Name, spec.Mode)
+	}
+	settings.Image = image.String()
+	settings.VM = true
+	for k, v := range provenance(application, method, image) {
+		req.Config[provenancePrefix+k] = v
+	}
+
+	log.Info("Creating virtual machine", "name", settings.Name, "image", settings.Image)
+	op, err := server.CreateInstance(req)
+	if err == nil {
+		err = op.Wait()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", settings.Name, err)
+	}
+	err = setInstanceState(server, settings.Name, "start")
+	if err != nil {
+		return err
+	}
+	if spec.Mode == vmModeCloudInit {
+		// the packages and commands run on first boot, and the user data can
+		// hold the root password
+		err = waitCloudInit(server, settings.Name, c.flagCloudInitTimeout)
+		unsetErr := unsetInstanceConfig(server, settings.Name, "cloud-init.user-data")
+		if err != nil {
+			if unsetErr != nil {
+				log.Error("Failed to remove the user data, remove cloud-init.user-data from the instance", "instance", settings.Name, "error", unsetErr)
+			}
+			return err
+		}
+		if unsetErr != nil {
+			return unsetErr
+		}
+		if settings.Proxy != "" && !settings.PersistProxy {
+			err = removeProxy(server, settings.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if spec.Mode == vmModeISO {
+		log.Info("Finish the installation on the console, then remove the iso device", "console", "incus console --type=vga "+settings.Name)
+	}
+
+	out, _ := WelcomeMessage(application, settings)
+	output, _ := glamour.Render(out, "dark")
+	fmt.Print(output)
+	return nil
+}
+
+// vmDevices builds the GPU, USB and disk devices chosen for a virtual
+// machine. Host paths are shared with the VM, which needs no shifting.
+func (c *cmdGlobal) vmDevices(server incus.InstanceServer, settings LaunchSettings, dataPool string, dataDir string) (map[string]map[string]string, error) {
+	devices, err := passthroughDevices(server, settings.Name, true, settings.Devices)
+	if err != nil {
+		return nil, err
+	}
+	if settings.GPU != "" {
+		gpus, err := c.hostGPUs()
+		if err != nil {
+			return nil, err
+		}
+		gpu, err := selectGPU(gpus, settings.GPU)
+		if err != nil {
+			return nil, err
+		}
+		if settings.GPUMdev != "" && !slices.Contains(gpu.Mdev, settings.GPUMdev) {
+			return nil, fmt.Errorf("mediated device %q is not available on GPU %s", settings.GPUMdev, gpu.PCIAddress)
+		}
+		devices["gpu"] = gpuDevice(gpu, true, settings.GPUMdev, "")
+	}
+	maps.Copy(devices, mountDevices(settings, false))
+	if settings.DataVolume {
+		devices["data"] = dataVolumeDevice(settings, dataPool, dataDir)
+	}
+	return devices, nil
+}
+
+// vmConfig sizes a virtual machine from the launch settings.
+func vmConfig(settings LaunchSettings, spec *VMSpec) map[string]string {
+	config := map[string]string{}
+	if settings.CPU > 0 {
+		config["limits.cpu"] = strconv.Itoa(settings.CPU)
+	}
+	if settings.RAM != "" {
+		config["limits.memory"] = settings.RAM
+	}
+	if spec.SecureBoot != nil && !*spec.SecureBoot {
+		config["security.secureboot"] = "false"
+	}
+	return config
+}
+
+// cloudVariant returns the cloud-init enabled variant of a distribution image,
+// when the image remote publishes one.
+func (c *cmdGlobal) cloudVariant(ref imageRef) imageRef {
+	imageServer, err := c.conf.GetImageServer(ref.Remote)
+	if err != nil {
+		return ref
+	}
+	return cloudVariant(imageServer, ref)
+}
+
+// cloudVariant looks up the /cloud VM image of ref on its image server.
+func cloudVariant(server incus.ImageServer, ref imageRef) imageRef {
+	cloud := ref
+	cloud.Name = ref.Name + "/cloud"
+	var err error
+	cloud.Fingerprint, err = checkImage(server, ref.Remote, cloud.Name, true)
+	if err != nil {
+		log.Warn("Image has no cloud variant, cloud-init may not run", "image", ref.String(), "error", err)
+		return ref
+	}
+	return cloud
+}
+
+// diskImageURL fills in the {version} placeholder of a disk image URL and
+// returns the URL and the version it points at.
+func diskImageURL(ctx context.Context, image *DiskImage) (string, string, error) {
+	if image.VersionURL == "" {
+		return image.URL, strings.TrimSuffix(path.Base(image.URL), "."+image.Compression), nil
+	}
+	f, err := defaultFetcher()
+	if err != nil {
+		return "", "", err
+	}
+	bb, err := f.fetch(ctx, image.VersionURL)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to look up the image version: %w", err)
+	}
+	var doc map[string]any
+	err = json.Unmarshal(bb, &doc)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse %s: %w", image.VersionURL, err)
+	}
+	version, ok := doc[image.VersionKey].(string)
+	if !ok || version == "" {
+		return "", "", fmt.Errorf("%s has no %q version", image.VersionURL, image.VersionKey)
+	}
+	return strings.ReplaceAll(image.URL, "{version}", version), version, nil
+}
+
+// downloadDiskImage downloads and decompresses a vendor image into dir.
+func downloadDiskImage(ctx context.Context, dir string, url string, image *DiskImage) (string, error) {
+	name := filepath.Join(dir, path.Base(url))
+	f, err := defaultFetcher()
+	if err != nil {
+		return "", err
+	}
+	err = f.fetchFile(ctx, url, name, path.Base(url))
+	if err != nil {
+		return "", err
+	}
+	out := filepath.Join(dir, "disk."+image.Format)
+	switch image.Compression {
+	case "":
+		return name, nil
+	case "xz":
+		err = decompressCommand(out, "xz", "-d", "-c", name)
+	case "gz":
+		err = decompressGzip(out, name)
+	case "zip":
+		err = decompressZip(out, name)
+	default:
+		err = fmt.Errorf("unknown compression %q", image.Compression)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress %s: %w", path.Base(url), err)
+	}
+	return out, os.Remove(name)
+}
+
+func decompressCommand(out string, name string, args ...string) error {
+	_, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf("the %s command is needed to decompress this image", name)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = f
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func decompressGzip(out string, name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	// some vendors append padding after the gzip stream
+	gz.Multistream(false)
+	return writeFileFrom(out, gz)
+}
+
+func decompressZip(out string, name string) error {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	if len(zr.File) != 1 {
+		return fmt.Errorf("expected one file in the archive, found %d", len(zr.File))
+	}
+	r, err := zr.File[0].Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return writeFileFrom(out, r)
+}
+
+func writeFileFrom(name string, r io.Reader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// importDiskImage imports a vendor disk image as an incus virtual machine
+// image, unless the same version was imported before.
+func importDiskImage(server incus.InstanceServer, application Application, image *DiskImage, arch string) (imageRef, error) {
+	ctx := context.Background()
+	url, version, err := diskImageURL(ctx, image)
+	if err != nil {
+		return imageRef{}, err
+	}
+	ref := imageRef{Name: vmImagePrefix + application.Slug + "/" + version, Version: version}
+	alias, _, err := server.GetImageAlias(ref.Name)
+	if err == nil {
+		log.Info("Using previously imported image", "image", ref.Name)
+		ref.Fingerprint = alias.Target
+		return ref, nil
+	}
+
+	dir, err := os.MkdirTemp("", "scriptcli-vm")
+	if err != nil {
+		return imageRef{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	log.Info("Downloading disk image", "url", url)
+	disk, err := downloadDiskImage(ctx, dir, url, image)
+	if err != nil {
+		return imageRef{}, err
+	}
+	if image.Format == "raw" {
+		// incus expects qcow2 virtual machine images
+		qcow2 := filepath.Join(dir, "disk.qcow2")
+		_, err = exec.LookPath("qemu-img")
+		if err != nil {
+			return imageRef{}, errors.New("the qemu-img command is needed to import raw disk images")
+		}
+		out, err := exec.Command("qemu-img", "convert", "-f", "raw", "-O", "qcow2", disk, qcow2).CombinedOutput()
+		if err != nil {
+			return imageRef{}, fmt.Errorf("failed to convert the disk image: %s", strings.TrimSpace(string(out)))
+		}
+		disk = qcow2
+	}
+
+	meta, err := imageMetadata(arch, application.Name+" "+version, application.Slug, version)
+	if err != nil {
+		return imageRef{}, err
+	}
+	rootfs, err := os.Open(disk)
+	if err != nil {
+		return imageRef{}, err
+	}
+	defer rootfs.Close()
+
+	log.Info("Importing disk image", "image", ref.Name)
+	op, err := server.CreateImage(api.ImagesPost{
+		ImagePut: api.ImagePut{Properties: map[string]string{"scriptcli.app": application.Slug, "scriptcli.url": url}},
+	}, &incus.ImageCreateArgs{
+		MetaFile:   bytes.NewReader(meta),
+		MetaName:   "metadata.tar.gz",
+		RootfsFile: rootfs,
+		RootfsName: "rootfs.img",
+		Type:       string(api.InstanceTypeVM),
+	})
+	if err == nil {
+		err = op.Wait()
+	}
+	if err != nil {
+		return imageRef{}, fmt.Errorf("failed to import %s: %w", url, err)
+	}
+	ref.Fingerprint, _ = op.Get().Metadata["fingerprint"].(string)
+	err = server.CreateImageAlias(api.ImageAliasesPost{
+		ImageAliasesEntry: api.ImageAliasesEntry{
+			ImageAliasesEntryPut: api.ImageAliasesEntryPut{Target: ref.Fingerprint},
+			Name:                 ref.Name,
+			Type:                 string(api.InstanceTypeVM),
+		},
+	})
+	if err != nil {
+		return imageRef{}, fmt.Errorf("failed to create image alias %s: %w", ref.Name, err)
+	}
+	return ref, nil
+}
+
+// imageMetadata builds the metadata tarball of a virtual machine image.
+func imageMetadata(arch string, description string, name string, release string) ([]byte, error) {
+	meta, err := yaml.Marshal(api.ImageMetadata{
+		Architecture: arch,
+		CreationDate: time.Now().Unix(),
+		Properties: map[string]string{
+			"description": description,
+			"os":          name,
+			"release":     release,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err = tw.WriteHeader(&tar.Header{Name: "metadata.yaml", Mode: 0o644, Size: int64(len(meta)), ModTime: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	_, err = tw.Write(meta)
+	if err != nil {
+		return nil, err
+	}
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// importISO uploads an installer ISO as a custom storage volume, unless it
+// was uploaded before.
+func importISO(server incus.InstanceServer, pool string, application Application, image *DiskImage) (string, error) {
+	ctx := context.Background()
+	url, version, err := diskImageURL(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	volume := strings.TrimSuffix(version, ".iso")
+	_, _, err = server.GetStoragePoolVolume(pool, "custom", volume)
+	if err == nil {
+		log.Info("Using previously imported iso", "volume", volume)
+		return volume, nil
+	}
+
+	dir, err := os.MkdirTemp("", "scriptcli-vm")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	log.Info("Downloading iso", "url", url)
+	iso, err := downloadDiskImage(ctx, dir, url, image)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(iso)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	log.Info("Importing iso", "pool", pool, "volume", volume)
+	op, err := server.CreateStoragePoolVolumeFromISO(pool, incus.StorageVolumeBackupArgs{BackupFile: f, Name: volume})
+	if err == nil {
+		err = op.Wait()
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to import %s for %s: %w", url, application.Name, err)
+	}
+	return volume, nil
+}
@@ -4,7 +4,7 @@ update_os() {
     echo "Acquire::http::Proxy-Auto-Detect \"/usr/local/bin/apt-proxy-detect.sh\";" >/etc/apt/apt.conf.d/00aptproxy
     cat <<EOF >/usr/local/bin/apt-proxy-detect.sh
 #!/bin/bash
-if nc -w1 -z "${CACHER_IP}" 3142; then
+if timeout 1 bash -c "</dev/tcp/${CACHER_IP}/3142" 2>/dev/null; then
   echo -n "http://${CACHER_IP}:3142"
 else
   echo -n "DIRECT"
@@ -8,6 +8,7 @@ export const dynamic = "force-static";
 const jsonDir = "public/json";
 const metadataFileName = "metadata.json";
 const indexFileName = "ct-index.json";
+const catalogIndexFileName = "index.json";
 
 const encoding = "utf-8";
 
@@ -22,6 +23,7 @@ const getScripts = async () => {
   const filePaths = (await fs.readdir(jsonDir))
     .filter((fileName) => fileName !== metadataFileName)
     .filter((fileName) => fileName !== indexFileName)
+    .filter((fileName) => fileName !== catalogIndexFileName)
     .map((fileName) => path.resolve(jsonDir, fileName));
 
   const scripts = await Promise.all(
@@ -1,5 +1,22 @@
 import { z } from "zod";
 
+const VMImageSchema = z.object({
+  url: z.string().url(),
+  format: z.enum(["qcow2", "raw", "iso"]),
+  compression: z.enum(["xz", "gz", "zip"]).optional(),
+  version_url: z.string().url().optional(),
+  version_key: z.string().min(1).optional(),
+});
+
+const VMSchema = z.object({
+  mode: z.enum(["cloud-init", "disk-image", "iso"]),
+  architectures: z.array(z.string().min(1)).optional(),
+  secure_boot: z.boolean().optional(),
+  packages: z.array(z.string().min(1)).optional(),
+  commands: z.array(z.string().min(1)).optional(),
+  image: VMImageSchema.optional(),
+});
+
 export const InstallMethodSchema = z.object({
   type: z.enum(["default", "alpine"], {
     errorMap: () => ({ message: "Type must be either 'default' or 'alpine'" })
@@ -12,6 +29,7 @@ export const InstallMethodSchema = z.object({
     os: z.string().nullable(),
     version: z.string().nullable(),
   }),
+  vm: VMSchema.optional(),
 });
 
 const NoteSchema = z.object({
@@ -29,7 +47,14 @@ export const ScriptSchema = z.object({
   }),
   updateable: z.boolean(),
   privileged: z.boolean(),
+  security: z.object({
+    nesting: z.boolean().optional(),
+    syscall_intercepts: z.array(z.enum(["mknod", "setxattr", "mount", "bpf", "sched_setscheduler", "sysinfo"])).optional(),
+    kernel_modules: z.array(z.string().regex(/^[a-z0-9_-]+$/, "Kernel module names are lower case")).optional(),
+    devices: z.array(z.string().regex(/^\/dev\/[a-z0-9_/-]+$/, "Devices are paths under /dev")).optional(),
+  }).optional(),
   interface_port: z.number().nullable(),
+  data_dir: z.string().startsWith("/", "Data directory must be an absolute path").optional(),
   documentation: z.string().nullable(),
   website: z.string().url().nullable(),
   logo: z.string().url().nullable(),